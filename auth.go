@@ -0,0 +1,245 @@
+package draken
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+const ContextKeyPrincipal ContextKey = "draken-principal"
+
+type AuthConfig struct {
+	Enabled  bool
+	Jwt      JwtConfig
+	ApiKeys  ApiKeyConfig
+	Sessions SessionConfig
+	Policy   PolicyConfig
+}
+
+type JwtConfig struct {
+	Enabled bool
+	// Algorithms are the accepted signing algorithms, e.g. HS256, RS256 or EdDSA.
+	Algorithms []string
+	// Secret is the key of the HS algorithms.
+	Secret string
+	// JwksFile is a JSON web key set holding the RS, EdDSA and HS keys,
+	// tokens select a key by their kid header.
+	JwksFile string
+	Issuer   string
+	Audience string
+	// Leeway is the accepted clock skew for the time based claims.
+	Leeway time.Duration
+}
+
+type ApiKeyConfig struct {
+	Enabled bool
+	// Header carries the api key, defaults to X-Api-Key.
+	Header string
+}
+
+type SessionConfig struct {
+	Enabled bool
+	// Cookie is the name of the session cookie.
+	Cookie string
+	// Ttl is extended on every request made with the session.
+	Ttl    time.Duration
+	Secure bool
+	Domain string
+}
+
+type PolicySource uint8
+
+const (
+	// PolicySourceConfig reads the roles from draken.auth.policy.roles.
+	PolicySourceConfig PolicySource = iota
+	// PolicySourceStorage reads the roles from the draken_role_permissions table.
+	PolicySourceStorage
+	PolicySourceUnknown PolicySource = 255
+)
+
+type PolicyConfig struct {
+	Source PolicySource
+	// Roles maps each role to the permissions it grants.
+	Roles map[string][]string
+}
+
+type AuthMethod uint8
+
+const (
+	AuthMethodJwt AuthMethod = iota
+	AuthMethodApiKey
+	AuthMethodSession
+	AuthMethodUnknown AuthMethod = 255
+)
+
+func (m AuthMethod) String() string {
+	switch m {
+	case AuthMethodJwt:
+		return "jwt"
+	case AuthMethodApiKey:
+		return "api_key"
+	case AuthMethodSession:
+		return "session"
+	}
+	return "unknown"
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Id is the subject, e.g. the user id.
+	Id     string         `json:"id"`
+	Method AuthMethod     `json:"method"`
+	Scopes []string       `json:"scopes,omitempty"`
+	Roles  []string       `json:"roles,omitempty"`
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Auth authenticates requests with the enabled methods.
+type Auth struct {
+	Config   AuthConfig
+	Jwt      *JwtVerifier
+	ApiKeys  *ApiKeyStore
+	Sessions *SessionStore
+}
+
+func (d *Draken) initAuth(ctx context.Context) error {
+	if !d.Config.Auth.Enabled {
+		log.Debug().Msgf("Auth is disabled in the config, skipping...")
+		return nil
+	}
+	log.Debug().Msgf("Initializing auth...")
+
+	cfg := d.Config.Auth
+	auth := &Auth{Config: cfg}
+	if cfg.Jwt.Enabled {
+		verifier, err := NewJwtVerifier(cfg.Jwt)
+		if err != nil {
+			return err
+		}
+		auth.Jwt = verifier
+	}
+	if cfg.ApiKeys.Enabled {
+		store, err := NewApiKeyStore(ctx, d.Storage)
+		if err != nil {
+			return err
+		}
+		auth.ApiKeys = store
+	}
+	if cfg.Sessions.Enabled {
+		auth.Sessions = NewSessionStore(d.Cache, cfg.Sessions)
+	}
+
+	d.Auth = auth
+	log.Info().Msgf("Auth initialized.")
+	return nil
+}
+
+// AuthMiddleware authenticates the request with the first credentials it
+// finds: a bearer token, an api key or a session cookie. Requests without
+// credentials pass as anonymous, invalid credentials are rejected with
+// ErrUnauthorized. Use RequireAuth to reject anonymous requests.
+func AuthMiddleware(a *Auth) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := a.Authenticate(c)
+			if err != nil {
+				return err
+			}
+			if principal != nil {
+				SetPrincipal(c, principal)
+			}
+			return next(c)
+		}
+	}
+}
+
+// Authenticate returns the principal of the request, or nil if the request
+// carries no credentials.
+func (a *Auth) Authenticate(c echo.Context) (*Principal, error) {
+	req := c.Request()
+	ctx := req.Context()
+
+	if a.Jwt != nil {
+		if token, ok := strings.CutPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+			return a.Jwt.Verify(token)
+		}
+	}
+	if a.ApiKeys != nil {
+		header := a.Config.ApiKeys.Header
+		if header == "" {
+			header = "X-Api-Key"
+		}
+		if key := req.Header.Get(header); key != "" {
+			return a.ApiKeys.Verify(ctx, key)
+		}
+	}
+	if a.Sessions != nil {
+		if cookie, err := req.Cookie(a.Sessions.Config.Cookie); err == nil && cookie.Value != "" {
+			return a.Sessions.Get(ctx, cookie.Value)
+		}
+	}
+	return nil, nil
+}
+
+type principalCtxKey struct{}
+
+// SetPrincipal stores the principal on the echo and the request context.
+func SetPrincipal(c echo.Context, p *Principal) {
+	c.Set(string(ContextKeyPrincipal), p)
+	c.Set(string(ContextKeyUserId), p.Id)
+	c.SetRequest(c.Request().WithContext(context.WithValue(c.Request().Context(), principalCtxKey{}, p)))
+}
+
+// PrincipalFrom returns the principal of the request, nil if it is anonymous.
+func PrincipalFrom(c echo.Context) *Principal {
+	p, _ := c.Get(string(ContextKeyPrincipal)).(*Principal)
+	return p
+}
+
+// PrincipalFromCtx returns the principal stored on a request context.
+func PrincipalFromCtx(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalCtxKey{}).(*Principal)
+	return p
+}
+
+// RequireAuth rejects anonymous requests with ErrUnauthorized.
+func RequireAuth() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if PrincipalFrom(c) == nil {
+				return ErrUnauthorized.New("authentication required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireScope rejects requests whose principal lacks any of the scopes.
+func RequireScope(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p := PrincipalFrom(c)
+			if p == nil {
+				return ErrUnauthorized.New("authentication required")
+			}
+			for _, scope := range scopes {
+				if !p.HasScope(scope) {
+					return ErrForbidden.New("missing scope %s", scope)
+				}
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package draken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"github.com/uptrace/bun"
+)
+
+const apiKeyPrefix = "dk"
+
+// ApiKey is a stored api key. Only the sha256 hash of the secret is kept,
+// the full key is returned once by CreateApiKey.
+type ApiKey struct {
+	bun.BaseModel `bun:"table:draken_api_keys"`
+
+	Id        string     `bun:",pk" json:"id"`
+	Hash      string     `bun:",notnull" json:"-"`
+	Subject   string     `bun:",notnull" json:"subject"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `bun:",notnull" json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ApiKeyStore keeps api keys in the storage.
+type ApiKeyStore struct {
+	Storage Storage
+}
+
+// NewApiKeyStore checks that the api key table exists. It is created by
+// the draken_api_keys migration, which is registered when api keys are
+// enabled.
+func NewApiKeyStore(ctx context.Context, storage Storage) (*ApiKeyStore, error) {
+	if storage == nil {
+		return nil, ErrInvalidConfig.New("api keys need an enabled storage")
+	}
+	if err := requireTable(ctx, storage, (*ApiKey)(nil), "draken_api_keys"); err != nil {
+		return nil, err
+	}
+	return &ApiKeyStore{Storage: storage}, nil
+}
+
+// CreateApiKey stores a new key for subject and returns it in the form
+// dk_<id>_<secret>. A ttl of 0 creates a key that does not expire.
+func (s *ApiKeyStore) CreateApiKey(ctx context.Context, subject, name string, scopes []string, ttl time.Duration) (string, *ApiKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, ErrInternal.Wrap(err, "generating the api key failed")
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	key := &ApiKey{
+		Id:        xid.New().String(),
+		Hash:      hashApiKeySecret(encoded),
+		Subject:   subject,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if _, err := s.Storage.Bun().NewInsert().Model(key).Exec(ctx); err != nil {
+		return "", nil, ErrInternal.Wrap(err, "storing the api key failed")
+	}
+	return apiKeyPrefix + "_" + key.Id + "_" + encoded, key, nil
+}
+
+// RevokeApiKey makes the key with id invalid.
+func (s *ApiKeyStore) RevokeApiKey(ctx context.Context, id string) error {
+	res, err := s.Storage.Bun().NewUpdate().Model((*ApiKey)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return ErrInternal.Wrap(err, "revoking api key %s failed", id)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound.New("api key %s does not exist", id)
+	}
+	return nil
+}
+
+// Verify checks the key and returns the principal of its subject.
+func (s *ApiKeyStore) Verify(ctx context.Context, raw string) (*Principal, error) {
+	parts := strings.SplitN(raw, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix {
+		return nil, ErrUnauthorized.New("malformed api key")
+	}
+
+	key := new(ApiKey)
+	err := s.Storage.Bun().NewSelect().Model(key).Where("id = ?", parts[1]).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnauthorized.New("invalid api key")
+	}
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "looking up the api key failed")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashApiKeySecret(parts[2]))) != 1 {
+		return nil, ErrUnauthorized.New("invalid api key")
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrUnauthorized.New("api key has been revoked")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrUnauthorized.New("api key has expired")
+	}
+
+	return &Principal{
+		Id:     key.Subject,
+		Method: AuthMethodApiKey,
+		Scopes: key.Scopes,
+	}, nil
+}
+
+// hashApiKeySecret hashes the random secret. A fast hash suffices as the
+// secrets have 256 bits of entropy.
+func hashApiKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,186 @@
+package draken
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joomcode/errorx"
+	"github.com/rs/zerolog/log"
+)
+
+// JwtVerifier verifies bearer tokens signed with a configured secret or a
+// key of the JWKS file.
+type JwtVerifier struct {
+	parser *jwt.Parser
+	secret []byte
+	keys   map[string]any
+}
+
+// NewJwtVerifier creates a verifier accepting tokens that expire, tokens
+// without an exp claim would be valid forever. The issuer and audience are
+// checked if they are configured.
+func NewJwtVerifier(cfg JwtConfig) (*JwtVerifier, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	v := &JwtVerifier{
+		parser: jwt.NewParser(opts...),
+		keys:   map[string]any{},
+	}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+	}
+	if cfg.JwksFile != "" {
+		raw, err := os.ReadFile(cfg.JwksFile)
+		if err != nil {
+			return nil, errorx.InitializationFailed.Wrap(err, "reading the jwks file failed")
+		}
+		if v.keys, err = parseJwks(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debug().Msgf("Loaded %d json web keys.", len(v.keys))
+	return v, nil
+}
+
+// Verify checks the token and returns its principal. The subject becomes
+// the id, the space separated scope or the scp claim the scopes and the
+// roles claim the roles.
+func (v *JwtVerifier) Verify(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, ErrUnauthorized.Wrap(err, "invalid token")
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, ErrUnauthorized.New("token has no subject")
+	}
+
+	p := &Principal{
+		Id:     sub,
+		Method: AuthMethodJwt,
+		Claims: claims,
+		Roles:  claimStrings(claims["roles"]),
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else {
+		p.Scopes = claimStrings(claims["scp"])
+	}
+	return p, nil
+}
+
+// key selects the key by the kid header, tokens without a kid use the
+// secret or the only key of the set.
+func (v *JwtVerifier) key(token *jwt.Token) (any, error) {
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, ErrUnauthorized.New("unknown key id %s", kid)
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok && v.secret != nil {
+		return v.secret, nil
+	}
+	if len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, nil
+		}
+	}
+	return nil, ErrUnauthorized.New("token has no key id")
+}
+
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	K   string `json:"k"`
+}
+
+// parseJwks reads the RSA, Ed25519 and symmetric keys of a JSON web key set.
+func parseJwks(raw []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, errorx.InitializationFailed.Wrap(err, "parsing the jwks file failed")
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errorx.InitializationFailed.Wrap(err, "parsing json web key %s failed", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errorx.IllegalArgument.New("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errorx.IllegalArgument.New("invalid Ed25519 key size %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decode(k.K)
+	}
+	return nil, errorx.IllegalArgument.New("unsupported key type %s", k.Kty)
+}
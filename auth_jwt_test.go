@@ -0,0 +1,55 @@
+package draken
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joomcode/errorx"
+)
+
+func TestJwtVerifier(t *testing.T) {
+	const secret = "test-secret"
+	v, err := NewJwtVerifier(JwtConfig{
+		Algorithms: []string{"HS256"},
+		Secret:     secret,
+		Issuer:     "draken",
+		Audience:   "api",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		valid  bool
+	}{
+		{"valid", jwt.MapClaims{"sub": "1", "iss": "draken", "aud": "api", "exp": exp}, true},
+		{"no exp", jwt.MapClaims{"sub": "1", "iss": "draken", "aud": "api"}, false},
+		{"expired", jwt.MapClaims{"sub": "1", "iss": "draken", "aud": "api", "exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{"wrong issuer", jwt.MapClaims{"sub": "1", "iss": "other", "aud": "api", "exp": exp}, false},
+		{"no issuer", jwt.MapClaims{"sub": "1", "aud": "api", "exp": exp}, false},
+		{"wrong audience", jwt.MapClaims{"sub": "1", "iss": "draken", "aud": "other", "exp": exp}, false},
+		{"no subject", jwt.MapClaims{"iss": "draken", "aud": "api", "exp": exp}, false},
+	}
+	for _, tt := range tests {
+		p, err := v.Verify(sign(tt.claims))
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.valid && p.Id != "1":
+			t.Errorf("%s: principal id = %q", tt.name, p.Id)
+		case !tt.valid && !errorx.IsOfType(err, ErrUnauthorized):
+			t.Errorf("%s: got %v, want ErrUnauthorized", tt.name, err)
+		}
+	}
+}
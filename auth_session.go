@@ -0,0 +1,94 @@
+package draken
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const sessionKeyPrefix = "session:"
+
+// SessionStore keeps server side sessions in the cache, the cookie only
+// holds the random session id.
+type SessionStore struct {
+	Cache  Cache
+	Config SessionConfig
+}
+
+func NewSessionStore(cache Cache, cfg SessionConfig) *SessionStore {
+	return &SessionStore{Cache: cache, Config: cfg}
+}
+
+// Create starts a session for the principal and sets the session cookie.
+func (s *SessionStore) Create(c echo.Context, p *Principal) error {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return ErrInternal.Wrap(err, "generating the session id failed")
+	}
+	sessionId := base64.RawURLEncoding.EncodeToString(id)
+
+	session := *p
+	session.Method = AuthMethodSession
+	data, err := json.Marshal(session)
+	if err != nil {
+		return ErrInternal.Wrap(err, "encoding the session failed")
+	}
+	if err := s.Cache.SetCtx(RequestCtx(c), sessionKeyPrefix+sessionId, data, s.Config.Ttl); err != nil {
+		return ErrInternal.Wrap(err, "storing the session failed")
+	}
+
+	c.SetCookie(s.cookie(sessionId, int(s.Config.Ttl.Seconds())))
+	return nil
+}
+
+// Destroy ends the session of the request and clears the cookie.
+func (s *SessionStore) Destroy(c echo.Context) error {
+	cookie, err := c.Cookie(s.Config.Cookie)
+	if err != nil {
+		return nil
+	}
+	if err := s.Cache.ExpireCtx(RequestCtx(c), sessionKeyPrefix+cookie.Value, 0); err != nil {
+		return ErrInternal.Wrap(err, "deleting the session failed")
+	}
+	c.SetCookie(s.cookie("", -1))
+	return nil
+}
+
+// Get returns the principal of the session and extends it by the ttl.
+func (s *SessionStore) Get(ctx context.Context, sessionId string) (*Principal, error) {
+	data, err := s.Cache.GetCtx(ctx, sessionKeyPrefix+sessionId)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUnauthorized.New("session expired")
+	}
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "reading the session failed")
+	}
+
+	p := new(Principal)
+	if err := json.Unmarshal([]byte(*data), p); err != nil {
+		return nil, ErrInternal.Wrap(err, "decoding the session failed")
+	}
+	if err := s.Cache.ExpireCtx(ctx, sessionKeyPrefix+sessionId, s.Config.Ttl); err != nil {
+		return nil, ErrInternal.Wrap(err, "extending the session failed")
+	}
+	return p, nil
+}
+
+func (s *SessionStore) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.Config.Cookie,
+		Value:    value,
+		Path:     "/",
+		Domain:   s.Config.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.Config.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
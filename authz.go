@@ -0,0 +1,262 @@
+package draken
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+)
+
+// RolePermission grants a permission to a role, it is the storage model of
+// policies loaded from the storage.
+type RolePermission struct {
+	bun.BaseModel `bun:"table:draken_role_permissions"`
+
+	Role       string `bun:",pk" json:"role"`
+	Permission string `bun:",pk" json:"permission"`
+}
+
+// Policy maps roles to the permissions they grant. A permission ending in
+// :* grants every permission with that prefix, * grants all of them. Role
+// names are case insensitive as viper lowercases map keys.
+type Policy struct {
+	mu    sync.RWMutex
+	roles map[string][]string
+}
+
+func NewPolicy(roles map[string][]string) *Policy {
+	p := &Policy{}
+	p.Set(roles)
+	return p
+}
+
+// Set replaces all roles of the policy.
+func (p *Policy) Set(roles map[string][]string) {
+	normalized := make(map[string][]string, len(roles))
+	for role, perms := range roles {
+		normalized[strings.ToLower(role)] = perms
+	}
+
+	p.mu.Lock()
+	p.roles = normalized
+	p.mu.Unlock()
+}
+
+// Allowed reports whether the principal holds the permission through one
+// of its roles. Scopes narrow the roles, a scoped principal needs the
+// permission as a scope as well. Principals without roles, e.g. api keys,
+// hold exactly their scopes. Scopes are never wildcards. A permission of
+// the form role:<name> requires the role itself.
+func (p *Policy) Allowed(principal *Principal, perm string) bool {
+	if role, ok := strings.CutPrefix(perm, "role:"); ok {
+		return slices.ContainsFunc(principal.Roles, func(r string) bool { return strings.EqualFold(r, role) })
+	}
+	inScope := slices.Contains(principal.Scopes, perm)
+	if len(principal.Scopes) > 0 && !inScope {
+		return false
+	}
+	if len(principal.Roles) == 0 {
+		return inScope
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, role := range principal.Roles {
+		for _, granted := range p.roles[strings.ToLower(role)] {
+			if permissionMatches(granted, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func permissionMatches(granted, perm string) bool {
+	if granted == "*" || granted == perm {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(granted, "*")
+	return ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(perm, prefix)
+}
+
+// PolicyStore loads the policy from the storage.
+type PolicyStore struct {
+	Storage Storage
+}
+
+// NewPolicyStore checks that the role permission table exists. It is
+// created by the draken_role_permissions migration, which is registered
+// when the policy source is the storage.
+func NewPolicyStore(ctx context.Context, storage Storage) (*PolicyStore, error) {
+	if storage == nil {
+		return nil, ErrInvalidConfig.New("storage policies need an enabled storage")
+	}
+	if err := requireTable(ctx, storage, (*RolePermission)(nil), "draken_role_permissions"); err != nil {
+		return nil, err
+	}
+	return &PolicyStore{Storage: storage}, nil
+}
+
+// Load reads all roles and their permissions.
+func (s *PolicyStore) Load(ctx context.Context) (map[string][]string, error) {
+	var rows []RolePermission
+	if err := s.Storage.Bun().NewSelect().Model(&rows).Scan(ctx); err != nil {
+		return nil, ErrInternal.Wrap(err, "loading the policy failed")
+	}
+
+	roles := make(map[string][]string)
+	for _, row := range rows {
+		roles[row.Role] = append(roles[row.Role], row.Permission)
+	}
+	return roles, nil
+}
+
+// Grant gives the permissions to the role.
+func (s *PolicyStore) Grant(ctx context.Context, role string, perms ...string) error {
+	rows := make([]RolePermission, len(perms))
+	for i, perm := range perms {
+		rows[i] = RolePermission{Role: strings.ToLower(role), Permission: perm}
+	}
+	_, err := s.Storage.Bun().NewInsert().Model(&rows).Ignore().Exec(ctx)
+	if err != nil {
+		return ErrInternal.Wrap(err, "granting permissions to role %s failed", role)
+	}
+	return nil
+}
+
+// Revoke takes the permissions from the role.
+func (s *PolicyStore) Revoke(ctx context.Context, role string, perms ...string) error {
+	_, err := s.Storage.Bun().NewDelete().Model((*RolePermission)(nil)).
+		Where("role = ?", strings.ToLower(role)).
+		Where("permission IN (?)", bun.In(perms)).
+		Exec(ctx)
+	if err != nil {
+		return ErrInternal.Wrap(err, "revoking permissions of role %s failed", role)
+	}
+	return nil
+}
+
+// Authorizer checks the permissions routers and routes require.
+type Authorizer struct {
+	Policy *Policy
+	// Store is set if the policy is loaded from the storage.
+	Store *PolicyStore
+}
+
+func (d *Draken) initAuthz(ctx context.Context) error {
+	if !d.Config.Auth.Enabled {
+		return nil
+	}
+	log.Debug().Msgf("Initializing authorization...")
+
+	cfg := d.Config.Auth.Policy
+	authz := &Authorizer{Policy: NewPolicy(cfg.Roles)}
+	if cfg.Source == PolicySourceStorage {
+		store, err := NewPolicyStore(ctx, d.Storage)
+		if err != nil {
+			return err
+		}
+		authz.Store = store
+		if err := authz.Reload(ctx); err != nil {
+			return err
+		}
+	}
+
+	d.Authz = authz
+	log.Info().Msgf("Authorization initialized.")
+	return nil
+}
+
+// Reload reads the policy from the storage again, so that changed grants
+// take effect without a restart.
+func (a *Authorizer) Reload(ctx context.Context) error {
+	if a.Store == nil {
+		return nil
+	}
+	roles, err := a.Store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	a.Policy.Set(roles)
+	log.Debug().Msgf("Loaded the permissions of %d roles.", len(roles))
+	return nil
+}
+
+// Authorize returns ErrUnauthorized for anonymous requests and ErrForbidden
+// if the principal lacks any of the permissions. Denials are logged.
+func (a *Authorizer) Authorize(c echo.Context, perms ...string) error {
+	p := PrincipalFrom(c)
+	if p == nil {
+		return ErrUnauthorized.New("authentication required")
+	}
+	for _, perm := range perms {
+		if !a.Policy.Allowed(p, perm) {
+			log.Warn().
+				Any("request_id", c.Get(string(ContextKeyRequestId))).
+				Str("principal", p.Id).
+				Str("permission", perm).
+				Str("route", c.Path()).
+				Msg("Permission denied.")
+			return ErrForbidden.New("missing permission %s", perm)
+		}
+	}
+	return nil
+}
+
+// Require returns a middleware rejecting requests whose principal lacks
+// any of the permissions, for use on single routes.
+func (a *Authorizer) Require(perms ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := a.Authorize(c, perms...); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Require makes all routes of the router and its subrouters require the
+// permissions, e.g. orders:write or role:admin. Requirements are checked
+// when a request is handled, so they also apply to routes and subrouters
+// created before the call.
+func (r *Router) Require(perms ...string) {
+	log.Debug().Str("route", r.Prefix).Msgf("Requiring %s for %s.", strings.Join(perms, ", "), r.Prefix)
+	r.permissions = append(r.permissions, perms...)
+}
+
+// requiredPermissions collects the permissions of the router and all of its
+// parents.
+func (r *Router) requiredPermissions() []string {
+	var perms []string
+	for router := r; router != nil; router = router.ParentRouter {
+		perms = append(perms, router.permissions...)
+	}
+	return perms
+}
+
+// authorize is added to every route of the router and enforces the
+// permissions required by the router tree.
+func (r *Router) authorize() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			perms := r.requiredPermissions()
+			if len(perms) == 0 {
+				return next(c)
+			}
+			authz := r.Draken.Authz
+			if authz == nil {
+				log.Error().Str("route", c.Path()).Msg("Route requires permissions but auth is disabled, denying the request.")
+				return ErrForbidden.New("authorization is not available")
+			}
+			if err := authz.Authorize(c, perms...); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package draken
+
+import "testing"
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := NewPolicy(map[string][]string{
+		"admin":  {"*"},
+		"editor": {"orders:*", "users:read"},
+	})
+
+	tests := []struct {
+		name      string
+		principal Principal
+		perm      string
+		want      bool
+	}{
+		{"role wildcard", Principal{Roles: []string{"Admin"}}, "users:delete", true},
+		{"role prefix", Principal{Roles: []string{"editor"}}, "orders:delete", true},
+		{"role lacks permission", Principal{Roles: []string{"editor"}}, "users:delete", false},
+		{"scope narrows role", Principal{Roles: []string{"admin"}, Scopes: []string{"orders:read"}}, "orders:delete", false},
+		{"scope within role", Principal{Roles: []string{"admin"}, Scopes: []string{"orders:read"}}, "orders:read", true},
+		{"scope beyond role", Principal{Roles: []string{"editor"}, Scopes: []string{"users:delete"}}, "users:delete", false},
+		{"exact scope without roles", Principal{Scopes: []string{"orders:read"}}, "orders:read", true},
+		{"scope star is no wildcard", Principal{Scopes: []string{"*"}}, "orders:read", false},
+		{"scope prefix is no wildcard", Principal{Scopes: []string{"orders:*"}}, "orders:read", false},
+		{"scope star with role", Principal{Roles: []string{"editor"}, Scopes: []string{"*"}}, "orders:read", false},
+		{"nothing", Principal{}, "orders:read", false},
+		{"required role", Principal{Roles: []string{"editor"}}, "role:EDITOR", true},
+		{"missing role", Principal{Scopes: []string{"role:admin"}}, "role:admin", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(&tt.principal, tt.perm); got != tt.want {
+			t.Errorf("%s: Allowed(%s) = %v, want %v", tt.name, tt.perm, got, tt.want)
+		}
+	}
+}
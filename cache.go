@@ -4,22 +4,40 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 )
 
+// Cache is a key value store. The methods without a context use the
+// lifetime context of the cache, handlers should use the Ctx variants with
+// the request context so that work stops when the request is cancelled.
 type Cache interface {
 	Init(bool)
 	Stop()
 	Get(key string) (*string, error)
+	// GetDel returns the value at key and deletes it in one step, so that
+	// only one caller gets it.
+	GetDel(key string) (*string, error)
 	Set(key string, value any, ttl time.Duration) error
 	Exists(key string) bool
 	Expire(key string, ttl time.Duration) error
 	Push(key string, value any) error
 	Pop(key string) (string, error)
 	Len(key string) (int64, error)
+	Incr(key string, ttl time.Duration) (int64, error)
+	GetCtx(ctx context.Context, key string) (*string, error)
+	GetDelCtx(ctx context.Context, key string) (*string, error)
+	SetCtx(ctx context.Context, key string, value any, ttl time.Duration) error
+	ExistsCtx(ctx context.Context, key string) bool
+	ExpireCtx(ctx context.Context, key string, ttl time.Duration) error
+	PushCtx(ctx context.Context, key string, value any) error
+	PopCtx(ctx context.Context, key string) (string, error)
+	LenCtx(ctx context.Context, key string) (int64, error)
+	IncrCtx(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	Ping(ctx context.Context) error
 }
 
 type Redis struct {
@@ -28,53 +46,67 @@ type Redis struct {
 	Cancel  context.CancelFunc
 }
 
-func (d *Draken) initCache() {
+func (d *Draken) initCache(ctx context.Context) error {
 	if !d.Config.Cache.Enabled {
 		log.Debug().Msgf("Cache is disabled in the config, skipping...")
-		return
+		return nil
 	}
 	log.Debug().Msgf("Initializing cache...")
 
 	switch d.Config.Cache.Type {
 	case CacheTypeRedis:
-		d.Cache = NewRedis(d.Config.Cache.DSN)
+		cache, err := NewRedis(ctx, d.Config.Cache.DSN, d.Config.Cache.Retry)
+		if err != nil {
+			return err
+		}
+		if d.Tracing != nil {
+			cache.Client.AddHook(d.Tracing.RedisHook())
+		}
+		d.Cache = cache
+		if d.Metrics != nil {
+			d.Metrics.Register(newRedisPoolCollector(cache.Client))
+		}
+	case CacheTypeLocal:
+		d.Cache = NewLocal()
 	}
+	d.Health.AddReadinessCheck("cache", d.Cache.Ping)
 	log.Info().Msgf("Cache initialized.")
+	return nil
 }
 
 // NewRedis creates a new Redis object
-func NewRedis(dsn string) *Redis {
-	ctx, cancel := context.WithCancel(context.Background())
+func NewRedis(ctx context.Context, dsn string, policy RetryPolicy) (*Redis, error) {
 	log.Debug().Msgf("Connecting to redis...")
-ConnectionStart:
 	opt, err := redis.ParseURL(dsn)
 	if err != nil {
-		log.Error().Msgf("Could not parse dsn: %v", err)
-		time.Sleep(10 * time.Second)
-		log.Warn().Msgf("Waiting for 10 seconds before trying to establish a new connection to redis...")
-		goto ConnectionStart
+		return nil, ErrConnectionFailed.Wrap(err, "could not parse redis dsn")
 	}
-	client := redis.NewClient(opt)
 
-	res := client.Ping(ctx)
-	if res.Err() != nil {
-		log.Error().Msgf("Could not connect to redis: %v", res.Err())
-		time.Sleep(10 * time.Second)
-		log.Warn().Msgf("Waiting for 10 seconds before trying to establish a new connection to redis...")
-		goto ConnectionStart
+	var client *redis.Client
+	err = policy.Do(ctx, "redis", func(ctx context.Context) error {
+		client = redis.NewClient(opt)
+		if err := client.Ping(ctx).Err(); err != nil {
+			client.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	rctx, cancel := context.WithCancel(context.Background())
 	rd := &Redis{
 		Client:  client,
-		Context: ctx,
+		Context: rctx,
 		Cancel:  cancel,
 	}
 	log.Info().Msgf("Connected to redis.")
-	return rd
+	return rd, nil
 }
 
 // Check if the Redis struct implements all Cache methods
-var _ = (*Redis)(nil)
+var _ Cache = (*Redis)(nil)
 
 func (r *Redis) Init(e bool) {
 	if !e {
@@ -98,10 +130,53 @@ func (r *Redis) Stop() {
 	}
 }
 
+func (r *Redis) Ping(ctx context.Context) error {
+	if r == nil || r.Client == nil {
+		return fmt.Errorf("redis client not initialized")
+	}
+	return r.Client.Ping(ctx).Err()
+}
+
 func (r *Redis) Get(key string) (*string, error) {
+	return r.GetCtx(r.Context, key)
+}
+
+func (r *Redis) GetDel(key string) (*string, error) {
+	return r.GetDelCtx(r.Context, key)
+}
+
+func (r *Redis) Set(key string, value any, ttl time.Duration) error {
+	return r.SetCtx(r.Context, key, value, ttl)
+}
+
+func (r *Redis) Exists(key string) bool {
+	return r.ExistsCtx(r.Context, key)
+}
+
+func (r *Redis) Expire(key string, ttl time.Duration) error {
+	return r.ExpireCtx(r.Context, key, ttl)
+}
+
+func (r *Redis) Push(key string, value any) error {
+	return r.PushCtx(r.Context, key, value)
+}
+
+func (r *Redis) Pop(key string) (string, error) {
+	return r.PopCtx(r.Context, key)
+}
+
+func (r *Redis) Len(key string) (int64, error) {
+	return r.LenCtx(r.Context, key)
+}
+
+func (r *Redis) Incr(key string, ttl time.Duration) (int64, error) {
+	return r.IncrCtx(r.Context, key, ttl)
+}
+
+func (r *Redis) GetCtx(ctx context.Context, key string) (*string, error) {
 	var result string
 
-	cmd := r.Client.Get(r.Context, key)
+	cmd := r.Client.Get(ctx, key)
 	if err := cmd.Err(); err != nil {
 		return nil, err
 	}
@@ -112,23 +187,31 @@ func (r *Redis) Get(key string) (*string, error) {
 	return &result, nil
 }
 
-func (r *Redis) Set(key string, value any, ttl time.Duration) error {
-	cmd := r.Client.Set(r.Context, key, value, ttl)
+func (r *Redis) GetDelCtx(ctx context.Context, key string) (*string, error) {
+	result, err := r.Client.GetDel(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *Redis) SetCtx(ctx context.Context, key string, value any, ttl time.Duration) error {
+	cmd := r.Client.Set(ctx, key, value, ttl)
 	return cmd.Err()
 }
 
-func (r *Redis) Exists(key string) bool {
-	cmd := r.Client.Exists(r.Context, key)
+func (r *Redis) ExistsCtx(ctx context.Context, key string) bool {
+	cmd := r.Client.Exists(ctx, key)
 	return cmd.Val() == 1
 }
 
-func (r *Redis) Expire(key string, ttl time.Duration) error {
-	cmd := r.Client.Expire(r.Context, key, ttl)
+func (r *Redis) ExpireCtx(ctx context.Context, key string, ttl time.Duration) error {
+	cmd := r.Client.Expire(ctx, key, ttl)
 	return cmd.Err()
 }
 
-// Push pushes a single value onto the tail of the list at key.
-func (r *Redis) Push(key string, value any) error {
+// PushCtx pushes a single value onto the tail of the list at key.
+func (r *Redis) PushCtx(ctx context.Context, key string, value any) error {
 	data, err := json.Marshal(value)
 	if err != nil {
 		log.Debug().Err(err).Msg("json  marshal failed")
@@ -140,22 +223,22 @@ func (r *Redis) Push(key string, value any) error {
 	}
 
 	// RPush returns *redis.IntCmd; Err() reflects any underlying error.
-	return r.Client.LPush(r.Context, key, string(data)).Err()
+	return r.Client.LPush(ctx, key, string(data)).Err()
 }
 
-// Pop removes and returns the head element of the list at key.
+// PopCtx removes and returns the head element of the list at key.
 // If the list is empty, it returns ("", nil), but you could also choose
 // to return ("", redis.Nil) and let the caller distinguish that yourself.
-func (r *Redis) Pop(key string) (string, error) {
+func (r *Redis) PopCtx(ctx context.Context, key string) (string, error) {
 	if r == nil || r.Client == nil {
 		return "", fmt.Errorf("redis client not initialized")
 	}
 
-	if !r.Exists(key) {
+	if !r.ExistsCtx(ctx, key) {
 		return "", nil
 	}
 
-	str, err := r.Client.RPop(r.Context, key).Result()
+	str, err := r.Client.RPop(ctx, key).Result()
 	if err == redis.Nil {
 		// List is empty
 		return "", nil
@@ -167,17 +250,17 @@ func (r *Redis) Pop(key string) (string, error) {
 	return str, nil
 }
 
-// Len returns the length of the list stored at key.
-func (r *Redis) Len(key string) (int64, error) {
+// LenCtx returns the length of the list stored at key.
+func (r *Redis) LenCtx(ctx context.Context, key string) (int64, error) {
 	if r == nil || r.Client == nil {
 		return -1, fmt.Errorf("redis client not initialized")
 	}
 
-	if !r.Exists(key) {
+	if !r.ExistsCtx(ctx, key) {
 		return -1, nil
 	}
 
-	size, err := r.Client.LLen(r.Context, key).Result()
+	size, err := r.Client.LLen(ctx, key).Result()
 	if err == redis.Nil {
 		// List is empty
 		return -1, nil
@@ -188,3 +271,65 @@ func (r *Redis) Len(key string) (int64, error) {
 	}
 	return size, nil
 }
+
+// IncrCtx increments the counter at key and sets ttl when the counter was
+// created by the increment.
+func (r *Redis) IncrCtx(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if r == nil || r.Client == nil {
+		return 0, fmt.Errorf("redis client not initialized")
+	}
+
+	return incrScript.Run(ctx, r.Client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+// incrScript increments KEYS[1] and sets the ttl of a new counter in one
+// step, a counter must never be left without its ttl.
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// takeTokenScript refills and takes from the token bucket at KEYS[1]
+// atomically. The bucket is stored as "<tokens>:<unix micros>" and the
+// redis clock is used so replicas with skewed clocks agree.
+var takeTokenScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tokens = burst
+local v = redis.call("GET", KEYS[1])
+if v then
+	local sep = string.find(v, ":")
+	tokens = tonumber(string.sub(v, 1, sep - 1))
+	local last = tonumber(string.sub(v, sep + 1))
+	tokens = math.min(burst, tokens + math.max(0, now - last) / 1000000 * rate)
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("SET", KEYS[1], string.format("%.6f", tokens) .. ":" .. now, "PX", ttl)
+return {allowed, string.format("%.6f", tokens)}
+`)
+
+// TakeToken takes a token from the bucket at key, which refills with rate
+// tokens per second up to burst.
+func (r *Redis) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	ttl := tokenBucketTTL(rate, burst)
+	res, err := takeTokenScript.Run(ctx, r.Client, []string{key}, rate, burst, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	allowed, _ := res[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(res[1]), 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed == 1, tokens, nil
+}
@@ -0,0 +1,465 @@
+package draken
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// localJanitorInterval is how often expired keys are evicted from the local cache.
+const localJanitorInterval = time.Minute
+
+// ErrLocalWrongType mirrors the WRONGTYPE error redis returns when a string
+// command is used on a list or vice versa.
+var ErrLocalWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ErrLocalNotInteger mirrors the error redis returns when incrementing a
+// value that is not an integer.
+var ErrLocalNotInteger = errors.New("ERR value is not an integer or out of range")
+
+type localEntry struct {
+	value     *string
+	list      []string
+	expiresAt time.Time
+}
+
+func (e *localEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// Local is an in-process Cache with the same semantics as Redis.
+// It is meant for local development and tests, values are not shared
+// between processes.
+type Local struct {
+	mu      sync.Mutex
+	entries map[string]*localEntry
+	Context context.Context
+	Cancel  context.CancelFunc
+}
+
+// NewLocal creates a new in-memory cache
+func NewLocal() *Local {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Local{
+		entries: make(map[string]*localEntry),
+		Context: ctx,
+		Cancel:  cancel,
+	}
+	go l.janitor()
+
+	log.Info().Msgf("Initialized local cache.")
+	return l
+}
+
+// Check if the Local struct implements all Cache methods
+var _ Cache = (*Local)(nil)
+
+func (l *Local) Init(e bool) {
+	if !e {
+		log.Debug().Msgf("Cache is disabled in the config, skipping initialization...")
+		return
+	}
+
+	log.Debug().Msgf("Local cache initialized.")
+}
+
+func (l *Local) Stop() {
+	if l.Cancel != nil {
+		l.Cancel()
+	}
+
+	l.mu.Lock()
+	l.entries = make(map[string]*localEntry)
+	l.mu.Unlock()
+	log.Info().Msgf("Local cache stopped.")
+}
+
+// janitor periodically removes expired keys so that keys which are never
+// read again do not accumulate.
+func (l *Local) janitor() {
+	ticker := time.NewTicker(localJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.Context.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, entry := range l.entries {
+				if entry.expired(now) {
+					delete(l.entries, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// lookup returns the live entry at key, lazily evicting it if it has expired.
+// The caller must hold l.mu.
+func (l *Local) lookup(key string) *localEntry {
+	entry, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.expired(time.Now()) {
+		delete(l.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// Ping always succeeds as the local cache has no connection to lose.
+// The Ctx variants only fail if the context is already done.
+func (l *Local) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (l *Local) Get(key string) (*string, error) {
+	return l.GetCtx(l.Context, key)
+}
+
+func (l *Local) GetDel(key string) (*string, error) {
+	return l.GetDelCtx(l.Context, key)
+}
+
+func (l *Local) Set(key string, value any, ttl time.Duration) error {
+	return l.SetCtx(l.Context, key, value, ttl)
+}
+
+func (l *Local) Exists(key string) bool {
+	return l.ExistsCtx(l.Context, key)
+}
+
+func (l *Local) Expire(key string, ttl time.Duration) error {
+	return l.ExpireCtx(l.Context, key, ttl)
+}
+
+func (l *Local) Push(key string, value any) error {
+	return l.PushCtx(l.Context, key, value)
+}
+
+func (l *Local) Pop(key string) (string, error) {
+	return l.PopCtx(l.Context, key)
+}
+
+func (l *Local) Len(key string) (int64, error) {
+	return l.LenCtx(l.Context, key)
+}
+
+func (l *Local) Incr(key string, ttl time.Duration) (int64, error) {
+	return l.IncrCtx(l.Context, key, ttl)
+}
+
+func (l *Local) GetCtx(ctx context.Context, key string) (*string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		return nil, redis.Nil
+	}
+	if entry.value == nil {
+		return nil, ErrLocalWrongType
+	}
+
+	result := *entry.value
+	return &result, nil
+}
+
+func (l *Local) GetDelCtx(ctx context.Context, key string) (*string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		return nil, redis.Nil
+	}
+	if entry.value == nil {
+		return nil, ErrLocalWrongType
+	}
+
+	delete(l.entries, key)
+	return entry.value, nil
+}
+
+// SetCtx stores value at key, replacing a list as well. Like redis, a zero
+// ttl stores the key without expiry and redis.KeepTTL keeps the expiry of
+// the replaced key.
+func (l *Local) SetCtx(ctx context.Context, key string, value any, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	str, err := localFormat(value)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := &localEntry{value: &str}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	} else if old := l.lookup(key); old != nil && ttl == redis.KeepTTL {
+		entry.expiresAt = old.expiresAt
+	}
+	l.entries[key] = entry
+	return nil
+}
+
+func (l *Local) ExistsCtx(ctx context.Context, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lookup(key) != nil
+}
+
+// ExpireCtx sets a ttl on an existing key. Like redis, a non-positive ttl
+// deletes the key and a missing key is not an error.
+func (l *Local) ExpireCtx(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		return nil
+	}
+	if ttl <= 0 {
+		delete(l.entries, key)
+		return nil
+	}
+	entry.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+// PushCtx pushes a single value onto the head of the list at key, PopCtx takes
+// from the tail so the list behaves as a FIFO queue just like Redis.
+func (l *Local) PushCtx(ctx context.Context, key string, value any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Debug().Err(err).Msg("json  marshal failed")
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		entry = &localEntry{}
+		l.entries[key] = entry
+	}
+	if entry.value != nil {
+		return ErrLocalWrongType
+	}
+
+	entry.list = append([]string{string(data)}, entry.list...)
+	return nil
+}
+
+// PopCtx removes and returns the tail element of the list at key.
+// If the list is empty, it returns ("", nil).
+func (l *Local) PopCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		return "", nil
+	}
+	if entry.value != nil {
+		return "", ErrLocalWrongType
+	}
+
+	last := len(entry.list) - 1
+	str := entry.list[last]
+	entry.list = entry.list[:last]
+
+	// Redis removes lists once their last element is popped
+	if len(entry.list) == 0 {
+		delete(l.entries, key)
+	}
+	return str, nil
+}
+
+// LenCtx returns the length of the list stored at key, -1 if it does not exist.
+func (l *Local) LenCtx(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		return -1, nil
+	}
+	if entry.value != nil {
+		return -1, ErrLocalWrongType
+	}
+	return int64(len(entry.list)), nil
+}
+
+// IncrCtx increments the counter at key and sets ttl when the counter was
+// created by the increment.
+func (l *Local) IncrCtx(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.lookup(key)
+	if entry == nil {
+		entry = &localEntry{value: new(string)}
+		*entry.value = "0"
+		if ttl > 0 {
+			entry.expiresAt = time.Now().Add(ttl)
+		}
+		l.entries[key] = entry
+	}
+	if entry.value == nil {
+		return 0, ErrLocalWrongType
+	}
+
+	n, err := strconv.ParseInt(*entry.value, 10, 64)
+	if err != nil {
+		return 0, ErrLocalNotInteger
+	}
+	n++
+	*entry.value = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+// TakeToken takes a token from the bucket at key, which refills with rate
+// tokens per second up to burst.
+func (l *Local) TakeToken(ctx context.Context, key string, rate float64, burst int) (bool, float64, error) {
+	if err := ctx.Err(); err != nil {
+		return false, 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	tokens := float64(burst)
+	entry := l.lookup(key)
+	if entry != nil && entry.value == nil {
+		return false, 0, ErrLocalWrongType
+	}
+	if entry != nil {
+		var last int64
+		if _, err := fmt.Sscanf(*entry.value, "%f:%d", &tokens, &last); err != nil {
+			return false, 0, ErrLocalWrongType
+		}
+		elapsed := now.Sub(time.UnixMicro(last)).Seconds()
+		tokens = min(float64(burst), tokens+max(0, elapsed)*rate)
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	value := fmt.Sprintf("%.6f:%d", tokens, now.UnixMicro())
+	l.entries[key] = &localEntry{value: &value, expiresAt: now.Add(tokenBucketTTL(rate, burst))}
+	return allowed, tokens, nil
+}
+
+// localFormat converts a value to its string form the same way go-redis
+// serializes command arguments, so Get returns identical results for both
+// backends.
+func localFormat(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case net.IP:
+		return string(v), nil
+	}
+
+	// go-redis dereferences pointers to the supported types
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return localFormat(reflect.Zero(rv.Type().Elem()).Interface())
+		}
+		return localFormat(rv.Elem().Interface())
+	}
+	return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", value)
+}
@@ -0,0 +1,223 @@
+package draken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cache, err := NewRedis(context.Background(), "redis://"+mr.Addr(), DefaultRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cache.Stop)
+	return cache, mr
+}
+
+func TestRedisIncrSetsTheTTLOfNewCounters(t *testing.T) {
+	ctx := context.Background()
+	cache, mr := newTestRedis(t)
+
+	for want := range int64(3) {
+		n, err := cache.IncrCtx(ctx, "counter", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want+1 {
+			t.Errorf("IncrCtx = %d, want %d", n, want+1)
+		}
+	}
+	if ttl := mr.TTL("counter"); ttl != time.Minute {
+		t.Errorf("ttl = %s, want %s", ttl, time.Minute)
+	}
+}
+
+// cacheBackend is a cache with a way to let its ttls elapse. Redis
+// truncates ttls of Expire to seconds, the local cache sleeps through a
+// short one.
+type cacheBackend struct {
+	cache   Cache
+	ttl     time.Duration
+	advance func(time.Duration)
+}
+
+// newTestCaches returns the local cache and redis, which have to behave the
+// same.
+func newTestCaches(t *testing.T) map[string]cacheBackend {
+	t.Helper()
+	local := NewLocal()
+	t.Cleanup(local.Stop)
+	cache, mr := newTestRedis(t)
+	return map[string]cacheBackend{
+		"local": {local, 50 * time.Millisecond, time.Sleep},
+		"redis": {cache, time.Second, mr.FastForward},
+	}
+}
+
+func TestCacheMissingKeys(t *testing.T) {
+	for name, b := range newTestCaches(t) {
+		c := b.cache
+		if _, err := c.Get("missing"); err != redis.Nil {
+			t.Errorf("%s: Get = %v, want redis.Nil", name, err)
+		}
+		if _, err := c.GetDel("missing"); err != redis.Nil {
+			t.Errorf("%s: GetDel = %v, want redis.Nil", name, err)
+		}
+		if c.Exists("missing") {
+			t.Errorf("%s: a missing key exists", name)
+		}
+		if v, err := c.Pop("missing"); v != "" || err != nil {
+			t.Errorf("%s: Pop = %q, %v, want an empty value", name, v, err)
+		}
+		if n, err := c.Len("missing"); n != -1 || err != nil {
+			t.Errorf("%s: Len = %d, %v, want -1", name, n, err)
+		}
+		if err := c.Expire("missing", time.Minute); err != nil {
+			t.Errorf("%s: Expire = %v", name, err)
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	for name, b := range newTestCaches(t) {
+		c, ttl := b.cache, b.ttl
+		get := func(key string) string {
+			v, err := c.Get(key)
+			if err == redis.Nil {
+				return "<missing>"
+			}
+			if err != nil {
+				t.Fatalf("%s: Get(%s) = %v", name, key, err)
+			}
+			return *v
+		}
+
+		for _, err := range []error{
+			c.Set("expiring", "1", ttl),
+			c.Set("kept", "1", ttl),
+			c.Set("kept", "2", redis.KeepTTL),
+			c.Set("cleared", "1", ttl),
+			c.Set("cleared", "2", 0),
+			c.Set("new", "1", redis.KeepTTL),
+			c.Set("expired", "1", 0),
+			c.Expire("expired", ttl),
+			c.Set("deleted", "1", 0),
+			c.Expire("deleted", 0),
+		} {
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if got := get("kept"); got != "2" {
+			t.Errorf("%s: kept = %s, want 2", name, got)
+		}
+		if c.Exists("deleted") {
+			t.Errorf("%s: a key expired with a zero ttl exists", name)
+		}
+
+		b.advance(2 * ttl)
+		for key, want := range map[string]string{
+			"expiring": "<missing>",
+			"kept":     "<missing>",
+			"cleared":  "2",
+			"new":      "1",
+			"expired":  "<missing>",
+		} {
+			if got := get(key); got != want {
+				t.Errorf("%s: %s = %s after the ttl, want %s", name, key, got, want)
+			}
+		}
+		if c.Exists("expiring") {
+			t.Errorf("%s: an expired key exists", name)
+		}
+	}
+}
+
+func TestCacheIncr(t *testing.T) {
+	for name, b := range newTestCaches(t) {
+		c, ttl := b.cache, b.ttl
+		for want := range int64(2) {
+			if n, err := c.Incr("counter", ttl); n != want+1 || err != nil {
+				t.Errorf("%s: Incr = %d, %v, want %d", name, n, err, want+1)
+			}
+		}
+		if v, err := c.Get("counter"); err != nil || *v != "2" {
+			t.Errorf("%s: the counter is %v, %v, want 2", name, v, err)
+		}
+		b.advance(2 * ttl)
+		if n, err := c.Incr("counter", ttl); n != 1 || err != nil {
+			t.Errorf("%s: Incr after the ttl = %d, %v, want a new counter", name, n, err)
+		}
+
+		if err := c.Set("text", "a", 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Incr("text", ttl); err == nil {
+			t.Errorf("%s: incrementing a string succeeded", name)
+		}
+		if err := c.Push("list", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Incr("list", ttl); err == nil {
+			t.Errorf("%s: incrementing a list succeeded", name)
+		}
+	}
+}
+
+func TestCacheGetDelAndLists(t *testing.T) {
+	for name, b := range newTestCaches(t) {
+		c := b.cache
+		if err := c.Set("once", 42, 0); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := c.GetDel("once"); err != nil || *v != "42" {
+			t.Errorf("%s: GetDel = %v, %v, want 42", name, v, err)
+		}
+		if _, err := c.GetDel("once"); err != redis.Nil {
+			t.Errorf("%s: second GetDel = %v, want redis.Nil", name, err)
+		}
+
+		for _, v := range []string{"a", "b"} {
+			if err := c.Push("queue", v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n, err := c.Len("queue"); n != 2 || err != nil {
+			t.Errorf("%s: Len = %d, %v, want 2", name, n, err)
+		}
+		if _, err := c.Get("queue"); err == nil || err == redis.Nil {
+			t.Errorf("%s: Get of a list = %v, want a wrong type error", name, err)
+		}
+		for _, want := range []string{`"a"`, `"b"`} {
+			if v, err := c.Pop("queue"); v != want || err != nil {
+				t.Errorf("%s: Pop = %s, %v, want %s", name, v, err, want)
+			}
+		}
+		if c.Exists("queue") {
+			t.Errorf("%s: an empty list exists", name)
+		}
+	}
+}
+
+func TestLocalEvictsExpiredKeys(t *testing.T) {
+	local := NewLocal()
+	t.Cleanup(local.Stop)
+	if err := local.Set("a", "1", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if local.Exists("a") {
+		t.Error("an expired key exists")
+	}
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	if _, ok := local.entries["a"]; ok {
+		t.Error("reading an expired key did not evict it")
+	}
+}
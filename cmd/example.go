@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -13,6 +14,14 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// e.g. go run ./cmd migrate status
+	if ok, err := d.CLI(os.Args[1:]); ok {
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	d.CreateRouter()
 	d.Router.EssentialMiddlewares()
 
@@ -1,7 +1,11 @@
 package draken
 
 import (
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -14,26 +18,96 @@ import (
 )
 
 type Config struct {
-	Environment Environment
-	Debug       bool
-	Server      ServerConfig
-	Storage     StorageConfig
-	Cache       CacheConfig
-	R2          R2Config
+	Environment   Environment
+	Debug         bool
+	Server        ServerConfig
+	Storage       StorageConfig
+	Cache         CacheConfig
+	ObjectStorage ObjectStorageConfig
+	// Deprecated: R2 points at ObjectStorage, use ObjectStorage instead.
+	R2      *R2Config
+	Tracing TracingConfig
+	Auth    AuthConfig
 }
 
 type ServerConfig struct {
-	Hidden    bool
-	Port      uint16
-	Heartbeat HeartbeatConfig
-	Security  bool
+	Hidden          bool
+	Port            uint16
+	Heartbeat       HeartbeatConfig
+	Security        bool
+	ShutdownTimeout time.Duration
+	// ShutdownDelay keeps serving requests while readiness fails at the
+	// start of a graceful shutdown, so load balancers can drain the app.
+	ShutdownDelay time.Duration
+	Metrics       MetricsConfig
+	// ReadTimeout, WriteTimeout, IdleTimeout and MaxHeaderBytes are set on
+	// the http server, a zero timeout disables it. WriteTimeout caps the
+	// request timeouts, routes running longer need a longer write timeout.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// RequestTimeout is the deadline of every request, routes can override
+	// it with the Timeout middleware. Zero disables it.
+	RequestTimeout time.Duration
+	// RateLimits are applied to the router with the matching prefix.
+	RateLimits []RateLimitConfig
 }
 
-type HeartbeatConfig struct {
+type RateLimitAlgorithm uint8
+
+const (
+	RateLimitTokenBucket RateLimitAlgorithm = iota
+	RateLimitSlidingWindow
+	RateLimitAlgorithmUnknown RateLimitAlgorithm = 255
+)
+
+type RateLimitKey uint8
+
+const (
+	RateLimitKeyIp RateLimitKey = iota
+	RateLimitKeyUser
+	RateLimitKeyUnknown RateLimitKey = 255
+)
+
+type RateLimitStoreType uint8
+
+const (
+	// RateLimitStoreMemory keeps the counters in the process.
+	RateLimitStoreMemory RateLimitStoreType = iota
+	// RateLimitStoreCache keeps the counters in the Cache, so replicas
+	// share the limit when the cache is redis.
+	RateLimitStoreCache
+	RateLimitStoreUnknown RateLimitStoreType = 255
+)
+
+type RateLimitConfig struct {
+	// Route is the prefix of the router the limit applies to, "/" is the
+	// root router.
+	Route     string
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed per Window.
+	Limit  int
+	Window time.Duration
+	// Burst is the capacity of the token bucket, defaults to Limit.
+	Burst int
+	Key   RateLimitKey
+	Store RateLimitStoreType
+}
+
+type MetricsConfig struct {
 	Enabled  bool
 	Endpoint string
 }
 
+type HeartbeatConfig struct {
+	Enabled   bool
+	Endpoint  string
+	Liveness  string
+	Readiness string
+	Timeout   time.Duration
+}
+
 type Environment uint8
 
 const (
@@ -49,33 +123,191 @@ const (
 	StorageTypeLibsql StorageType = iota
 	StorageTypeSqlite
 	StorageTypePostgres
+	StorageTypeUnknown StorageType = 255
 )
 
 type StorageConfig struct {
 	Enabled bool
 	Type    StorageType
 	DSN     string
+	Retry   RetryPolicy
+	// TxRetry is how often Storage.WithTx retries conflicting transactions.
+	TxRetry    RetryPolicy
+	Migrations MigrationConfig
+	Sqlite     SqliteConfig
+	Postgres   PostgresConfig
+	Replicas   ReplicaConfig
+}
+
+type ReplicaSelection uint8
+
+const (
+	ReplicaSelectionRoundRobin ReplicaSelection = iota
+	// ReplicaSelectionLeastLatency prefers the replica with the lowest
+	// average health check latency.
+	ReplicaSelectionLeastLatency
+	ReplicaSelectionUnknown ReplicaSelection = 255
+)
+
+type ReplicaConfig struct {
+	// Dsns of the read replicas, they use the settings of the primary.
+	Dsns      []string
+	Selection ReplicaSelection
+	// CheckInterval is how often the replicas are pinged, failing replicas
+	// get no reads until they pass again.
+	CheckInterval time.Duration
+}
+
+type PostgresConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	DialTimeout     time.Duration
+	// ReadTimeout bounds the wait for a response of the server, it must
+	// exceed StatementTimeout so that slow statements are cancelled by
+	// postgres instead of leaving them running on a closed connection.
+	// Defaults to 10s, or 10s more than StatementTimeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// StatementTimeout aborts statements running longer, zero disables it.
+	StatementTimeout time.Duration
+	ApplicationName  string
+	Tls              PostgresTlsConfig
+}
+
+type PostgresTlsMode uint8
+
+const (
+	// PostgresTlsDsn keeps the sslmode of the dsn.
+	PostgresTlsDsn PostgresTlsMode = iota
+	PostgresTlsDisable
+	// PostgresTlsRequire encrypts without verifying the server certificate.
+	PostgresTlsRequire
+	// PostgresTlsVerifyCa verifies the certificate chain but not the host name.
+	PostgresTlsVerifyCa
+	PostgresTlsVerifyFull
+	PostgresTlsUnknown PostgresTlsMode = 255
+)
+
+func (m PostgresTlsMode) String() string {
+	switch m {
+	case PostgresTlsDsn:
+		return "dsn"
+	case PostgresTlsDisable:
+		return "disable"
+	case PostgresTlsRequire:
+		return "require"
+	case PostgresTlsVerifyCa:
+		return "verify-ca"
+	case PostgresTlsVerifyFull:
+		return "verify-full"
+	}
+	return "unknown"
+}
+
+type PostgresTlsConfig struct {
+	Mode PostgresTlsMode
+	// CaFile verifies the server, the system roots are used if it is empty.
+	CaFile string
+	// CertFile and KeyFile are the client certificate.
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+type SqliteConfig struct {
+	// Path is the database file or :memory:, it defaults to
+	// ~/draken/data/main.db.
+	Path string
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	// BusyTimeout is how long a connection waits for a locked database.
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	// CacheSize is the page cache size, negative values are in KiB. Zero
+	// keeps the sqlite default.
+	CacheSize int
+}
+
+type MigrationConfig struct {
+	// AutoApply applies pending migrations when the storage is initialized.
+	AutoApply bool
+	// Directory is where the migrate create commands write new migrations.
+	Directory  string
+	Table      string
+	LocksTable string
+	// LockTimeout bounds the wait for a migration run of another replica.
+	LockTimeout time.Duration
 }
 
 type CacheType uint8
 
 const (
 	CacheTypeRedis CacheType = iota
+	CacheTypeLocal
+	CacheTypeUnknown CacheType = 255
 )
 
 type CacheConfig struct {
 	Enabled bool
 	Type    CacheType
 	DSN     string
+	Retry   RetryPolicy
 }
 
-type R2Config struct {
-	Enabled         bool
+type ObjectStorageProvider uint8
+
+const (
+	ObjectStorageProviderR2 ObjectStorageProvider = iota
+	ObjectStorageProviderS3
+	// ObjectStorageProviderCustom is any S3 compatible store, e.g. MinIO.
+	ObjectStorageProviderCustom
+	ObjectStorageProviderUnknown ObjectStorageProvider = 255
+)
+
+type R2Jurisdiction uint8
+
+const (
+	R2JurisdictionDefault R2Jurisdiction = iota
+	R2JurisdictionEU
+	R2JurisdictionFedRAMP
+	R2JurisdictionUnknown R2Jurisdiction = 255
+)
+
+type ChecksumPolicy uint8
+
+const (
+	// ChecksumWhenSupported calculates checksums for every operation that supports them.
+	ChecksumWhenSupported ChecksumPolicy = iota
+	// ChecksumWhenRequired only calculates checksums the operation requires,
+	// for stores that reject the newer checksum algorithms.
+	ChecksumWhenRequired
+	ChecksumUnknown ChecksumPolicy = 255
+)
+
+type ObjectStorageConfig struct {
+	Enabled  bool
+	Provider ObjectStorageProvider
+	// Jurisdiction selects the R2 endpoint, it is ignored by other providers.
+	Jurisdiction R2Jurisdiction
+	Region       string
+	// Bucket is used by all object operations that do not name a bucket.
+	Bucket string
+	// Endpoint replaces the endpoint derived from the provider.
+	Endpoint        string
+	PathStyle       bool
+	Checksum        ChecksumPolicy
 	AccountId       string
 	AccessKeyId     string
 	AccessKeySecret string
 }
 
+// R2Config is the former name of ObjectStorageConfig.
+type R2Config = ObjectStorageConfig
+
 func (d *Draken) setup() error {
 	d.StartedAt = time.Now()
 	if err := d.loadConfigFile(); err != nil {
@@ -88,33 +320,53 @@ func (d *Draken) setup() error {
 	d.setServerConfig()
 	d.setStorageConfig()
 	d.setCacheConfig()
-	d.setR2Config()
+	d.setObjectStorageConfig()
+	d.setTracingConfig()
+	d.setAuthConfig()
 
-	return nil
+	return d.Config.Validate()
 }
 
 func (d *Draken) loadConfigFile() error {
 	log.Debug().Msgf("Loading environment variables...")
-	if err := godotenv.Load(".env"); err != nil {
-		log.Debug().Msgf("Environment file could not be loaded, defaulting to provided environment variables.")
+	for _, file := range d.options.EnvFiles {
+		if err := godotenv.Load(file); err != nil {
+			log.Debug().Msgf("Environment file %s could not be loaded, defaulting to provided environment variables.", file)
+		}
 	}
 
 	log.Debug().Msgf("Loading config file...")
-	raw, err := os.ReadFile(".config/draken.yaml")
-	if err != nil {
-		return errorx.DataUnavailable.New("loading config file failed")
+	path := d.options.ConfigPath
+	if env := os.Getenv(ConfigPathEnv); env != "" {
+		path = env
 	}
 
-	log.Debug().Msgf("Substituting environment variables...")
-	substituted, err := envsubst.String(string(raw))
+	var raw []byte
+	var err error
+	if d.options.ConfigReader != nil {
+		raw, err = io.ReadAll(d.options.ConfigReader)
+		path = ""
+	} else {
+		raw, err = os.ReadFile(path)
+	}
 	if err != nil {
-		return errorx.RejectedOperation.New("substituting env variables failed")
+		return errorx.DataUnavailable.Wrap(err, "loading config file %s failed", path)
 	}
 
-	log.Debug().Msgf("Setting configuration...")
 	viper.SetConfigType("yaml")
-	if err := viper.ReadConfig(strings.NewReader(substituted)); err != nil {
-		return errorx.RejectedOperation.New("reading config by viper failed")
+	if err := readConfig(raw, viper.ReadConfig); err != nil {
+		return err
+	}
+
+	for _, overlay := range d.overlays(path) {
+		log.Debug().Msgf("Merging config overlay %s...", overlay)
+		raw, err := os.ReadFile(overlay)
+		if err != nil {
+			return errorx.DataUnavailable.Wrap(err, "loading config overlay %s failed", overlay)
+		}
+		if err := readConfig(raw, viper.MergeConfig); err != nil {
+			return errorx.Decorate(err, "merging config overlay %s failed", overlay)
+		}
 	}
 
 	log.Debug().Msgf("Registered keys %s in the configuration.", strings.Join(viper.AllKeys(), ", "))
@@ -122,6 +374,36 @@ func (d *Draken) loadConfigFile() error {
 	return nil
 }
 
+// readConfig substitutes environment variables in raw and hands the result to viper.
+func readConfig(raw []byte, read func(io.Reader) error) error {
+	log.Debug().Msgf("Substituting environment variables...")
+	substituted, err := envsubst.String(string(raw))
+	if err != nil {
+		return errorx.RejectedOperation.Wrap(err, "substituting env variables failed")
+	}
+
+	log.Debug().Msgf("Setting configuration...")
+	if err := read(strings.NewReader(substituted)); err != nil {
+		return errorx.RejectedOperation.Wrap(err, "reading config by viper failed")
+	}
+	return nil
+}
+
+// overlays returns the config files merged on top of the base config at path.
+// The per-environment overlay next to the base file (e.g. draken.prod.yaml)
+// comes first and is skipped if it does not exist, explicit overlays follow.
+func (d *Draken) overlays(path string) []string {
+	var overlays []string
+	if env := viper.GetString("draken.environment"); path != "" && env != "" {
+		ext := filepath.Ext(path)
+		overlay := strings.TrimSuffix(path, ext) + "." + env + ext
+		if _, err := os.Stat(overlay); err == nil {
+			overlays = append(overlays, overlay)
+		}
+	}
+	return append(overlays, d.options.Overlays...)
+}
+
 func (d *Draken) setLoggerOpts() {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if d.Config.Debug {
@@ -164,17 +446,83 @@ func (d *Draken) setStorageConfig() {
 	case "libsql":
 		storageType = StorageTypeLibsql
 		dsn = viper.GetString("draken.storage.libsql.dsn")
-	case "sqlite":
+	case "sqlite", "":
 		storageType = StorageTypeSqlite
 	case "postgres":
 		storageType = StorageTypePostgres
 		dsn = viper.GetString("draken.storage.postgres.dsn")
 	default:
-		storageType = StorageTypeSqlite
+		storageType = StorageTypeUnknown
 	}
 	d.Config.Storage.Enabled = enabled
 	d.Config.Storage.DSN = dsn
 	d.Config.Storage.Type = storageType
+	d.Config.Storage.Retry = readRetryPolicy("draken.storage.retry")
+	d.Config.Storage.TxRetry = readRetryPolicyOr("draken.storage.txRetry", DefaultTxRetryPolicy())
+
+	replicas := &d.Config.Storage.Replicas
+	replicas.Dsns = viper.GetStringSlice("draken.storage.replicas.dsns")
+	switch viper.GetString("draken.storage.replicas.selection") {
+	case "roundRobin", "":
+		replicas.Selection = ReplicaSelectionRoundRobin
+	case "leastLatency":
+		replicas.Selection = ReplicaSelectionLeastLatency
+	default:
+		replicas.Selection = ReplicaSelectionUnknown
+	}
+	replicas.CheckInterval = durationOr("draken.storage.replicas.checkInterval", 5*time.Second)
+
+	pg := &d.Config.Storage.Postgres
+	pg.MaxOpenConns = intOr("draken.storage.postgres.maxOpenConns", 4*runtime.GOMAXPROCS(0))
+	pg.MaxIdleConns = intOr("draken.storage.postgres.maxIdleConns", 2*runtime.GOMAXPROCS(0))
+	pg.ConnMaxLifetime = durationOr("draken.storage.postgres.connMaxLifetime", 30*time.Minute)
+	pg.ConnMaxIdleTime = durationOr("draken.storage.postgres.connMaxIdleTime", 5*time.Minute)
+	pg.DialTimeout = durationOr("draken.storage.postgres.dialTimeout", 5*time.Second)
+	pg.WriteTimeout = durationOr("draken.storage.postgres.writeTimeout", 5*time.Second)
+	pg.StatementTimeout = viper.GetDuration("draken.storage.postgres.statementTimeout")
+	pg.ReadTimeout = durationOr("draken.storage.postgres.readTimeout", max(10*time.Second, pg.StatementTimeout+10*time.Second))
+	pg.ApplicationName = stringOr("draken.storage.postgres.applicationName", "draken")
+	switch viper.GetString("draken.storage.postgres.tls.mode") {
+	case "dsn", "":
+		pg.Tls.Mode = PostgresTlsDsn
+	case "disable":
+		pg.Tls.Mode = PostgresTlsDisable
+	case "require":
+		pg.Tls.Mode = PostgresTlsRequire
+	case "verify-ca":
+		pg.Tls.Mode = PostgresTlsVerifyCa
+	case "verify-full":
+		pg.Tls.Mode = PostgresTlsVerifyFull
+	default:
+		pg.Tls.Mode = PostgresTlsUnknown
+	}
+	pg.Tls.CaFile = viper.GetString("draken.storage.postgres.tls.caFile")
+	pg.Tls.CertFile = viper.GetString("draken.storage.postgres.tls.certFile")
+	pg.Tls.KeyFile = viper.GetString("draken.storage.postgres.tls.keyFile")
+	pg.Tls.ServerName = viper.GetString("draken.storage.postgres.tls.serverName")
+
+	sqlite := &d.Config.Storage.Sqlite
+	sqlite.Path = viper.GetString("draken.storage.sqlite.path")
+	// the legacy wal key only applies without an explicit journal mode
+	journalMode := "WAL"
+	if viper.IsSet("draken.storage.sqlite.wal") && !viper.GetBool("draken.storage.sqlite.wal") {
+		journalMode = "DELETE"
+	}
+	sqlite.JournalMode = strings.ToUpper(stringOr("draken.storage.sqlite.journalMode", journalMode))
+	sqlite.Synchronous = strings.ToUpper(stringOr("draken.storage.sqlite.synchronous", "NORMAL"))
+	sqlite.BusyTimeout = durationOr("draken.storage.sqlite.busyTimeout", 5*time.Second)
+	sqlite.ForeignKeys = true
+	if viper.IsSet("draken.storage.sqlite.foreignKeys") {
+		sqlite.ForeignKeys = viper.GetBool("draken.storage.sqlite.foreignKeys")
+	}
+	sqlite.CacheSize = viper.GetInt("draken.storage.sqlite.cacheSize")
+
+	migrations := &d.Config.Storage.Migrations
+	migrations.AutoApply = viper.GetBool("draken.storage.migrations.autoApply")
+	migrations.Directory = stringOr("draken.storage.migrations.directory", "migrations")
+	migrations.Table = stringOr("draken.storage.migrations.table", "draken_migrations")
+	migrations.LocksTable = stringOr("draken.storage.migrations.locksTable", "draken_migration_locks")
+	migrations.LockTimeout = durationOr("draken.storage.migrations.lockTimeout", 5*time.Minute)
 }
 
 func (d *Draken) setCacheConfig() {
@@ -183,17 +531,20 @@ func (d *Draken) setCacheConfig() {
 	dsn := ""
 	cacheType := CacheTypeRedis
 	switch str {
-	case "redis":
+	case "redis", "":
 		cacheType = CacheTypeRedis
 		dsn = viper.GetString("draken.cache.redis.dsn")
+	case "local":
+		cacheType = CacheTypeLocal
+		dsn = viper.GetString("draken.cache.local.dsn")
 	default:
-		cacheType = CacheTypeRedis
-		dsn = viper.GetString("draken.cache.redis.dsn")
+		cacheType = CacheTypeUnknown
 	}
 
 	d.Config.Cache.Enabled = enabled
 	d.Config.Cache.Type = cacheType
 	d.Config.Cache.DSN = dsn
+	d.Config.Cache.Retry = readRetryPolicy("draken.cache.retry")
 }
 
 func (d *Draken) setServerConfig() {
@@ -202,15 +553,221 @@ func (d *Draken) setServerConfig() {
 	d.Config.Server.Security = viper.GetBool("draken.server.security")
 	d.Config.Server.Heartbeat.Enabled = viper.GetBool("draken.server.heartbeat.enabled")
 	d.Config.Server.Heartbeat.Endpoint = viper.GetString("draken.server.heartbeat.endpoint")
+	d.Config.Server.Heartbeat.Liveness = stringOr("draken.server.heartbeat.liveness", "/livez")
+	d.Config.Server.Heartbeat.Readiness = stringOr("draken.server.heartbeat.readiness", "/readyz")
+	d.Config.Server.Heartbeat.Timeout = durationOr("draken.server.heartbeat.timeout", 2*time.Second)
+	d.Config.Server.ShutdownDelay = viper.GetDuration("draken.server.shutdownDelay")
+	d.Config.Server.Metrics.Enabled = viper.GetBool("draken.server.metrics.enabled")
+	d.Config.Server.Metrics.Endpoint = stringOr("draken.server.metrics.endpoint", "/metrics")
+	d.Config.Server.ShutdownTimeout = durationOr("draken.server.shutdownTimeout", 10*time.Second)
+	d.Config.Server.ReadTimeout = durationOr("draken.server.readTimeout", 30*time.Second)
+	d.Config.Server.WriteTimeout = durationOr("draken.server.writeTimeout", 60*time.Second)
+	d.Config.Server.IdleTimeout = durationOr("draken.server.idleTimeout", 120*time.Second)
+	d.Config.Server.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	if viper.IsSet("draken.server.maxHeaderBytes") {
+		d.Config.Server.MaxHeaderBytes = viper.GetInt("draken.server.maxHeaderBytes")
+	}
+	d.Config.Server.RequestTimeout = durationOr("draken.server.requestTimeout", 30*time.Second)
+	d.setRateLimitConfig()
 }
 
-func (d *Draken) setR2Config() {
-	d.Config.R2.Enabled = viper.GetBool("draken.r2.enabled")
-	d.Config.R2.AccountId = viper.GetString("draken.r2.accountId")
-	d.Config.R2.AccessKeyId = viper.GetString("draken.r2.accessKeyId")
-	d.Config.R2.AccessKeySecret = viper.GetString("draken.r2.accessKeySecret")
+// setRateLimitConfig reads the draken.server.rateLimits list. It is a list
+// instead of a map keyed by route as viper lower cases map keys.
+func (d *Draken) setRateLimitConfig() {
+	var raw []struct {
+		Route     string
+		Algorithm string
+		Limit     int
+		Window    time.Duration
+		Burst     int
+		Key       string
+		Store     string
+	}
+	if err := viper.UnmarshalKey("draken.server.rateLimits", &raw); err != nil {
+		log.Error().Err(err).Msg("Reading draken.server.rateLimits failed.")
+	}
+
+	d.Config.Server.RateLimits = nil
+	for _, r := range raw {
+		cfg := RateLimitConfig{
+			Route:  r.Route,
+			Limit:  r.Limit,
+			Window: r.Window,
+			Burst:  r.Burst,
+		}
+		switch r.Algorithm {
+		case "tokenBucket", "":
+			cfg.Algorithm = RateLimitTokenBucket
+		case "slidingWindow":
+			cfg.Algorithm = RateLimitSlidingWindow
+		default:
+			cfg.Algorithm = RateLimitAlgorithmUnknown
+		}
+		switch r.Key {
+		case "ip", "":
+			cfg.Key = RateLimitKeyIp
+		case "user":
+			cfg.Key = RateLimitKeyUser
+		default:
+			cfg.Key = RateLimitKeyUnknown
+		}
+		switch r.Store {
+		case "memory", "":
+			cfg.Store = RateLimitStoreMemory
+		case "cache":
+			cfg.Store = RateLimitStoreCache
+		default:
+			cfg.Store = RateLimitStoreUnknown
+		}
+		if cfg.Burst == 0 {
+			cfg.Burst = cfg.Limit
+		}
+		d.Config.Server.RateLimits = append(d.Config.Server.RateLimits, cfg)
+	}
+}
+
+// setObjectStorageConfig reads draken.objectStorage, falling back to the
+// legacy draken.r2 section which always used the EU jurisdiction.
+func (d *Draken) setObjectStorageConfig() {
+	key := "draken.objectStorage"
+	cfg := &d.Config.ObjectStorage
+	d.Config.R2 = cfg
+	if !viper.IsSet(key) && viper.IsSet("draken.r2") {
+		key = "draken.r2"
+		cfg.Jurisdiction = R2JurisdictionEU
+	} else {
+		switch viper.GetString(key + ".jurisdiction") {
+		case "default", "":
+			cfg.Jurisdiction = R2JurisdictionDefault
+		case "eu":
+			cfg.Jurisdiction = R2JurisdictionEU
+		case "fedramp":
+			cfg.Jurisdiction = R2JurisdictionFedRAMP
+		default:
+			cfg.Jurisdiction = R2JurisdictionUnknown
+		}
+	}
+
+	cfg.Enabled = viper.GetBool(key + ".enabled")
+	switch viper.GetString(key + ".provider") {
+	case "r2", "":
+		cfg.Provider = ObjectStorageProviderR2
+	case "s3":
+		cfg.Provider = ObjectStorageProviderS3
+	case "custom":
+		cfg.Provider = ObjectStorageProviderCustom
+	default:
+		cfg.Provider = ObjectStorageProviderUnknown
+	}
+
+	// R2 rejects the checksums newer SDKs send by default
+	defaultChecksum := "when_supported"
+	if cfg.Provider == ObjectStorageProviderR2 {
+		defaultChecksum = "when_required"
+	}
+	switch stringOr(key+".checksum", defaultChecksum) {
+	case "when_supported":
+		cfg.Checksum = ChecksumWhenSupported
+	case "when_required":
+		cfg.Checksum = ChecksumWhenRequired
+	default:
+		cfg.Checksum = ChecksumUnknown
+	}
+
+	cfg.Region = viper.GetString(key + ".region")
+	cfg.Bucket = viper.GetString(key + ".bucket")
+	cfg.Endpoint = viper.GetString(key + ".endpoint")
+	cfg.PathStyle = viper.GetBool(key + ".pathStyle")
+	if key == "draken.r2" && cfg.Endpoint != "" {
+		// the legacy section used path style for every custom endpoint
+		cfg.PathStyle = true
+	}
+	cfg.AccountId = viper.GetString(key + ".accountId")
+	cfg.AccessKeyId = viper.GetString(key + ".accessKeyId")
+	cfg.AccessKeySecret = viper.GetString(key + ".accessKeySecret")
+}
+
+func (d *Draken) setTracingConfig() {
+	d.Config.Tracing.Enabled = viper.GetBool("draken.tracing.enabled")
+	switch viper.GetString("draken.tracing.exporter") {
+	case "stdout", "":
+		d.Config.Tracing.Exporter = TracingExporterStdout
+	case "otlp":
+		d.Config.Tracing.Exporter = TracingExporterOtlp
+	default:
+		d.Config.Tracing.Exporter = TracingExporterUnknown
+	}
+	d.Config.Tracing.ServiceName = stringOr("draken.tracing.serviceName", "draken")
+	d.Config.Tracing.Endpoint = stringOr("draken.tracing.endpoint", "localhost:4318")
+	d.Config.Tracing.Insecure = viper.GetBool("draken.tracing.insecure")
+	d.Config.Tracing.SampleRatio = 1
+	if viper.IsSet("draken.tracing.sampleRatio") {
+		d.Config.Tracing.SampleRatio = viper.GetFloat64("draken.tracing.sampleRatio")
+	}
+}
+
+// stringOr returns the string at key or def if the key is not set.
+func stringOr(key string, def string) string {
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetString(key)
+}
+
+// intOr returns the int at key or def if the key is not set.
+func intOr(key string, def int) int {
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetInt(key)
+}
+
+// durationOr returns the duration at key or def if the key is not set.
+func durationOr(key string, def time.Duration) time.Duration {
+	if !viper.IsSet(key) {
+		return def
+	}
+	return viper.GetDuration(key)
 }
 
 func (d *Draken) OverwriteLogger(logger zerolog.Logger) {
 	log.Logger = logger
 }
+
+func (d *Draken) setAuthConfig() {
+	cfg := &d.Config.Auth
+	cfg.Enabled = viper.GetBool("draken.auth.enabled")
+
+	cfg.Jwt.Enabled = viper.GetBool("draken.auth.jwt.enabled")
+	cfg.Jwt.Algorithms = viper.GetStringSlice("draken.auth.jwt.algorithms")
+	if len(cfg.Jwt.Algorithms) == 0 {
+		cfg.Jwt.Algorithms = []string{"HS256"}
+	}
+	cfg.Jwt.Secret = viper.GetString("draken.auth.jwt.secret")
+	cfg.Jwt.JwksFile = viper.GetString("draken.auth.jwt.jwksFile")
+	cfg.Jwt.Issuer = viper.GetString("draken.auth.jwt.issuer")
+	cfg.Jwt.Audience = viper.GetString("draken.auth.jwt.audience")
+	cfg.Jwt.Leeway = viper.GetDuration("draken.auth.jwt.leeway")
+
+	cfg.ApiKeys.Enabled = viper.GetBool("draken.auth.apiKeys.enabled")
+	cfg.ApiKeys.Header = stringOr("draken.auth.apiKeys.header", "X-Api-Key")
+
+	cfg.Sessions.Enabled = viper.GetBool("draken.auth.sessions.enabled")
+	cfg.Sessions.Cookie = stringOr("draken.auth.sessions.cookie", "draken_session")
+	cfg.Sessions.Ttl = durationOr("draken.auth.sessions.ttl", 24*time.Hour)
+	cfg.Sessions.Secure = true
+	if viper.IsSet("draken.auth.sessions.secure") {
+		cfg.Sessions.Secure = viper.GetBool("draken.auth.sessions.secure")
+	}
+	cfg.Sessions.Domain = viper.GetString("draken.auth.sessions.domain")
+
+	switch viper.GetString("draken.auth.policy.source") {
+	case "config", "":
+		cfg.Policy.Source = PolicySourceConfig
+	case "storage":
+		cfg.Policy.Source = PolicySourceStorage
+	default:
+		cfg.Policy.Source = PolicySourceUnknown
+	}
+	cfg.Policy.Roles = viper.GetStringMapStringSlice("draken.auth.policy.roles")
+}
@@ -0,0 +1,382 @@
+package draken
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ConfigProblem is a single invalid setting found by Config.Validate.
+type ConfigProblem struct {
+	// Key is the yaml key path of the offending setting, e.g. draken.server.port.
+	Key     string
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+type configProblems []ConfigProblem
+
+func (p *configProblems) add(key, format string, args ...any) {
+	*p = append(*p, ConfigProblem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks every config section and reports all problems at once.
+// The returned error is of type ErrInvalidConfig and carries the problems
+// in the PropertyConfigProblems property.
+func (c *Config) Validate() error {
+	var problems configProblems
+	c.validateServer(&problems)
+	c.validateStorage(&problems)
+	c.validateCache(&problems)
+	c.validateObjectStorage(&problems)
+	c.validateTracing(&problems)
+	c.validateAuth(&problems)
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(problems))
+	for _, p := range problems {
+		msgs = append(msgs, p.String())
+	}
+	return ErrInvalidConfig.
+		New("%d configuration problem(s): %s", len(problems), strings.Join(msgs, "; ")).
+		WithProperty(PropertyConfigProblems, []ConfigProblem(problems))
+}
+
+func (c *Config) validateServer(p *configProblems) {
+	if c.Server.Port == 0 {
+		p.add("draken.server.port", "must be set to a port between 1 and 65535")
+	}
+
+	if c.Server.ShutdownTimeout <= 0 {
+		p.add("draken.server.shutdownTimeout", "must be a positive duration")
+	}
+
+	if c.Server.ShutdownDelay < 0 || c.Server.ShutdownDelay >= c.Server.ShutdownTimeout {
+		p.add("draken.server.shutdownDelay", "must be between 0 and draken.server.shutdownTimeout")
+	}
+
+	timeouts := []struct {
+		key     string
+		timeout time.Duration
+	}{
+		{"draken.server.readTimeout", c.Server.ReadTimeout},
+		{"draken.server.writeTimeout", c.Server.WriteTimeout},
+		{"draken.server.idleTimeout", c.Server.IdleTimeout},
+		{"draken.server.requestTimeout", c.Server.RequestTimeout},
+	}
+	for _, t := range timeouts {
+		if t.timeout < 0 {
+			p.add(t.key, "must not be negative")
+		}
+	}
+	if c.Server.WriteTimeout > 0 && c.Server.RequestTimeout > c.Server.WriteTimeout {
+		p.add("draken.server.requestTimeout", "must not exceed draken.server.writeTimeout, the response could not be written")
+	}
+	if c.Server.MaxHeaderBytes <= 0 {
+		p.add("draken.server.maxHeaderBytes", "must be positive")
+	}
+
+	for i, r := range c.Server.RateLimits {
+		key := fmt.Sprintf("draken.server.rateLimits[%d]", i)
+		if !strings.HasPrefix(r.Route, "/") {
+			p.add(key+".route", "must start with /, got %q", r.Route)
+		}
+		if r.Algorithm == RateLimitAlgorithmUnknown {
+			p.add(key+".algorithm", "unknown algorithm, expected one of tokenBucket, slidingWindow")
+		}
+		if r.Key == RateLimitKeyUnknown {
+			p.add(key+".key", "unknown key, expected one of ip, user")
+		}
+		if r.Store == RateLimitStoreUnknown {
+			p.add(key+".store", "unknown store, expected one of memory, cache")
+		}
+		if r.Store == RateLimitStoreCache && !c.Cache.Enabled {
+			p.add(key+".store", "the cache store requires draken.cache.enabled")
+		}
+		if r.Limit <= 0 {
+			p.add(key+".limit", "must be positive")
+		}
+		if r.Window <= 0 {
+			p.add(key+".window", "must be a positive duration")
+		}
+		if r.Burst < 0 {
+			p.add(key+".burst", "must not be negative")
+		}
+	}
+
+	if c.Server.Metrics.Enabled && !strings.HasPrefix(c.Server.Metrics.Endpoint, "/") {
+		p.add("draken.server.metrics.endpoint", "must start with / when metrics are enabled, got %q", c.Server.Metrics.Endpoint)
+	}
+
+	if c.Server.Heartbeat.Enabled {
+		endpoints := [][2]string{
+			{"draken.server.heartbeat.endpoint", c.Server.Heartbeat.Endpoint},
+			{"draken.server.heartbeat.liveness", c.Server.Heartbeat.Liveness},
+			{"draken.server.heartbeat.readiness", c.Server.Heartbeat.Readiness},
+		}
+		for _, e := range endpoints {
+			if !strings.HasPrefix(e[1], "/") {
+				p.add(e[0], "must start with / when the heartbeat is enabled, got %q", e[1])
+			}
+		}
+		if c.Server.Heartbeat.Timeout <= 0 {
+			p.add("draken.server.heartbeat.timeout", "must be a positive duration")
+		}
+	}
+}
+
+func (c *Config) validateStorage(p *configProblems) {
+	if !c.Storage.Enabled {
+		return
+	}
+
+	c.Storage.Retry.validate("draken.storage.retry", p)
+	c.Storage.TxRetry.validate("draken.storage.txRetry", p)
+	if c.Storage.Migrations.LockTimeout <= 0 {
+		p.add("draken.storage.migrations.lockTimeout", "must be a positive duration")
+	}
+	if replicas := c.Storage.Replicas; len(replicas.Dsns) > 0 {
+		if c.Storage.Type == StorageTypeSqlite {
+			p.add("draken.storage.replicas.dsns", "read replicas require the libsql or postgres storage type")
+		}
+		if replicas.Selection == ReplicaSelectionUnknown {
+			p.add("draken.storage.replicas.selection", "unknown selection, expected one of roundRobin, leastLatency")
+		}
+		if replicas.CheckInterval <= 0 {
+			p.add("draken.storage.replicas.checkInterval", "must be a positive duration")
+		}
+	}
+
+	switch c.Storage.Type {
+	case StorageTypeSqlite:
+		sqlite := c.Storage.Sqlite
+		if !slices.Contains([]string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}, sqlite.JournalMode) {
+			p.add("draken.storage.sqlite.journalMode", "unknown journal mode %q, expected one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL, OFF", sqlite.JournalMode)
+		}
+		if !slices.Contains([]string{"OFF", "NORMAL", "FULL", "EXTRA"}, sqlite.Synchronous) {
+			p.add("draken.storage.sqlite.synchronous", "unknown synchronous level %q, expected one of OFF, NORMAL, FULL, EXTRA", sqlite.Synchronous)
+		}
+		if sqlite.BusyTimeout < 0 {
+			p.add("draken.storage.sqlite.busyTimeout", "must not be negative")
+		}
+	case StorageTypeLibsql:
+		if c.Storage.DSN == "" {
+			p.add("draken.storage.libsql.dsn", "must be set when the storage type is libsql")
+		}
+	case StorageTypePostgres:
+		if c.Storage.DSN == "" {
+			p.add("draken.storage.postgres.dsn", "must be set when the storage type is postgres")
+		} else if u, err := url.Parse(c.Storage.DSN); err != nil {
+			p.add("draken.storage.postgres.dsn", "is not a valid url")
+		} else if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+			p.add("draken.storage.postgres.dsn", "must use the postgres:// scheme, got %q", u.Scheme)
+		}
+		c.validatePostgres(p)
+	default:
+		p.add("draken.storage.type", "unknown storage type, expected one of libsql, sqlite, postgres")
+	}
+}
+
+func (c *Config) validatePostgres(p *configProblems) {
+	pg := c.Storage.Postgres
+	if pg.MaxOpenConns < 0 {
+		p.add("draken.storage.postgres.maxOpenConns", "must not be negative")
+	}
+	if pg.MaxIdleConns < 0 {
+		p.add("draken.storage.postgres.maxIdleConns", "must not be negative")
+	}
+	if pg.MaxOpenConns > 0 && pg.MaxIdleConns > pg.MaxOpenConns {
+		p.add("draken.storage.postgres.maxIdleConns", "must not exceed maxOpenConns (%d)", pg.MaxOpenConns)
+	}
+	durations := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"connMaxLifetime", pg.ConnMaxLifetime},
+		{"connMaxIdleTime", pg.ConnMaxIdleTime},
+		{"dialTimeout", pg.DialTimeout},
+		{"readTimeout", pg.ReadTimeout},
+		{"writeTimeout", pg.WriteTimeout},
+		{"statementTimeout", pg.StatementTimeout},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			p.add("draken.storage.postgres."+d.key, "must not be negative")
+		}
+	}
+	// a read timeout below the statement timeout closes the connection
+	// before postgres cancels the statement, leaving it running
+	if pg.StatementTimeout > 0 && pg.ReadTimeout > 0 && pg.ReadTimeout <= pg.StatementTimeout {
+		p.add("draken.storage.postgres.readTimeout", "must exceed statementTimeout (%s)", pg.StatementTimeout)
+	}
+
+	switch pg.Tls.Mode {
+	case PostgresTlsUnknown:
+		p.add("draken.storage.postgres.tls.mode", "unknown tls mode, expected one of dsn, disable, require, verify-ca, verify-full")
+	case PostgresTlsDsn, PostgresTlsDisable:
+		if pg.Tls.CaFile != "" || pg.Tls.CertFile != "" {
+			p.add("draken.storage.postgres.tls.mode", "must be require, verify-ca or verify-full when tls files are set")
+		}
+	}
+	if (pg.Tls.CertFile == "") != (pg.Tls.KeyFile == "") {
+		p.add("draken.storage.postgres.tls.certFile", "must be set together with keyFile")
+	}
+	files := [][2]string{{"caFile", pg.Tls.CaFile}, {"certFile", pg.Tls.CertFile}, {"keyFile", pg.Tls.KeyFile}}
+	for _, f := range files {
+		if f[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(f[1]); err != nil {
+			p.add("draken.storage.postgres.tls."+f[0], "cannot be read: %v", err)
+		}
+	}
+}
+
+func (c *Config) validateCache(p *configProblems) {
+	if !c.Cache.Enabled {
+		return
+	}
+
+	c.Cache.Retry.validate("draken.cache.retry", p)
+
+	switch c.Cache.Type {
+	case CacheTypeLocal:
+	case CacheTypeRedis:
+		if c.Cache.DSN == "" {
+			p.add("draken.cache.redis.dsn", "must be set when the cache type is redis")
+		} else if _, err := redis.ParseURL(c.Cache.DSN); err != nil {
+			p.add("draken.cache.redis.dsn", "is not a valid redis url: %v", err)
+		}
+	default:
+		p.add("draken.cache.type", "unknown cache type, expected one of redis, local")
+	}
+}
+
+func (c *Config) validateObjectStorage(p *configProblems) {
+	cfg := c.ObjectStorage
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.Endpoint != "" {
+		if u, err := url.Parse(cfg.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			p.add("draken.objectStorage.endpoint", "must be an absolute url, got %q", cfg.Endpoint)
+		}
+	}
+	if cfg.Checksum == ChecksumUnknown {
+		p.add("draken.objectStorage.checksum", "unknown checksum policy, expected one of when_supported, when_required")
+	}
+
+	switch cfg.Provider {
+	case ObjectStorageProviderR2:
+		if cfg.Jurisdiction == R2JurisdictionUnknown {
+			p.add("draken.objectStorage.jurisdiction", "unknown jurisdiction, expected one of default, eu, fedramp")
+		}
+		if cfg.Endpoint == "" && cfg.AccountId == "" {
+			p.add("draken.objectStorage.accountId", "must be set for r2 without a custom endpoint")
+		}
+	case ObjectStorageProviderS3:
+		if cfg.Region == "" {
+			p.add("draken.objectStorage.region", "must be set for s3")
+		}
+	case ObjectStorageProviderCustom:
+		if cfg.Endpoint == "" {
+			p.add("draken.objectStorage.endpoint", "must be set for custom providers")
+		}
+	default:
+		p.add("draken.objectStorage.provider", "unknown provider, expected one of r2, s3, custom")
+	}
+
+	// s3 falls back to the default AWS credential chain
+	if cfg.Provider != ObjectStorageProviderS3 || cfg.AccessKeyId != "" || cfg.AccessKeySecret != "" {
+		if cfg.AccessKeyId == "" {
+			p.add("draken.objectStorage.accessKeyId", "must be set when object storage is enabled")
+		}
+		if cfg.AccessKeySecret == "" {
+			p.add("draken.objectStorage.accessKeySecret", "must be set when object storage is enabled")
+		}
+	}
+}
+
+func (c *Config) validateTracing(p *configProblems) {
+	if !c.Tracing.Enabled {
+		return
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingExporterStdout:
+	case TracingExporterOtlp:
+		if c.Tracing.Endpoint == "" {
+			p.add("draken.tracing.endpoint", "must be set when the otlp exporter is used")
+		}
+	default:
+		p.add("draken.tracing.exporter", "unknown exporter, expected one of stdout, otlp")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		p.add("draken.tracing.sampleRatio", "must be between 0 and 1")
+	}
+}
+
+func (c *Config) validateAuth(p *configProblems) {
+	cfg := c.Auth
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.Jwt.Enabled {
+		for _, alg := range cfg.Jwt.Algorithms {
+			switch {
+			case strings.HasPrefix(alg, "HS"):
+				if cfg.Jwt.Secret == "" && cfg.Jwt.JwksFile == "" {
+					p.add("draken.auth.jwt.secret", "must be set for %s without a jwks file", alg)
+				}
+			case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"), alg == "EdDSA":
+				if cfg.Jwt.JwksFile == "" {
+					p.add("draken.auth.jwt.jwksFile", "must be set for %s", alg)
+				}
+			default:
+				p.add("draken.auth.jwt.algorithms", "unsupported algorithm %s, expected HS*, RS*, PS* or EdDSA", alg)
+			}
+		}
+		if cfg.Jwt.Secret != "" && len(cfg.Jwt.Secret) < 32 {
+			p.add("draken.auth.jwt.secret", "must be at least 32 bytes long")
+		}
+		if cfg.Jwt.Leeway < 0 {
+			p.add("draken.auth.jwt.leeway", "must not be negative")
+		}
+	}
+	if cfg.ApiKeys.Enabled && !c.Storage.Enabled {
+		p.add("draken.auth.apiKeys.enabled", "api keys require draken.storage.enabled")
+	}
+	if cfg.Sessions.Enabled {
+		if !c.Cache.Enabled {
+			p.add("draken.auth.sessions.enabled", "sessions require draken.cache.enabled")
+		}
+		if cfg.Sessions.Cookie == "" {
+			p.add("draken.auth.sessions.cookie", "must be set when sessions are enabled")
+		}
+		if cfg.Sessions.Ttl <= 0 {
+			p.add("draken.auth.sessions.ttl", "must be a positive duration")
+		}
+	}
+	switch cfg.Policy.Source {
+	case PolicySourceUnknown:
+		p.add("draken.auth.policy.source", "must be one of config or storage")
+	case PolicySourceStorage:
+		if !c.Storage.Enabled {
+			p.add("draken.auth.policy.source", "storage policies require draken.storage.enabled")
+		}
+	}
+}
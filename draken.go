@@ -20,51 +20,77 @@ type Draken struct {
 	Cache     Cache
 	StartedAt time.Time
 	R2        *R2
-	Router    *Router
+	// ObjectStore is the configured R2 client or the store passed with
+	// WithObjectStore.
+	ObjectStore ObjectStore
+	Router      *Router
+	Lifecycle   *Lifecycle
+	Health      *Health
+	Metrics     *Metrics
+	Tracing     *Tracing
+	Auth        *Auth
+	Authz       *Authorizer
+	Migrator    *Migrator
+	options     Options
 }
 
-func New() (*Draken, error) {
-	d := &Draken{}
+func New(opts ...Option) (*Draken, error) {
+	d := &Draken{
+		Lifecycle: NewLifecycle(),
+		options:   defaultOptions(),
+	}
+	for _, opt := range opts {
+		opt(&d.options)
+	}
+
 	if err := d.setup(); err != nil {
 		return nil, errorx.Decorate(err, "setup failed")
 	}
-	d.initStorage()
-	d.initCache()
-	d.initR2()
+	d.Health = NewHealth(d.Config.Server.Heartbeat.Timeout)
+	if d.Config.Server.Metrics.Enabled {
+		d.Metrics = NewMetrics()
+	}
+
+	// Components are started in dependency order and stopped in reverse,
+	// tracing comes first so that spans of the other components are flushed
+	d.Lifecycle.Append("tracing", d.initTracing, func(ctx context.Context) error {
+		if d.Tracing != nil {
+			return d.Tracing.Stop(ctx)
+		}
+		return nil
+	})
+	d.Lifecycle.Append("storage", d.initStorage, func(context.Context) error {
+		if d.Storage != nil {
+			d.Storage.Stop()
+		}
+		return nil
+	})
+	d.Lifecycle.Append("cache", d.initCache, func(context.Context) error {
+		if d.Cache != nil {
+			d.Cache.Stop()
+		}
+		return nil
+	})
+	d.Lifecycle.Append("objectStorage", d.initObjectStore, func(context.Context) error {
+		if d.ObjectStore != nil {
+			d.ObjectStore.Stop()
+		}
+		return nil
+	})
+	d.Lifecycle.Append("auth", d.initAuth, nil)
+	d.Lifecycle.Append("authz", d.initAuthz, nil)
+	if err := d.Lifecycle.Start(d.options.Context); err != nil {
+		return nil, err
+	}
 
 	log.Info().Msg("Created Draken app.")
 	return d, nil
 }
 
 func (d *Draken) Serve() error {
-	// Channel to listen for termination signals
-	idleConnsClosed := make(chan struct{})
-
-	go func() {
-		// Listen for OS interrupt or termination signals
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
-		<-sigint
-
-		log.Debug().Msg("Graceful shutdown initiated.")
-		// Initiate graceful shutdown with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if err := d.Router.Echo.Shutdown(ctx); err != nil {
-			log.Error().Err(errorx.IllegalState.New("shutdown failed")).Send()
-		}
-		close(idleConnsClosed)
-	}()
-
-	log.Info().Msgf("Listening on port %d", d.Config.Server.Port)
-	if err := d.Router.Echo.Start(fmt.Sprintf(":%d", d.Config.Server.Port)); err != http.ErrServerClosed {
-		return err
-	}
-
-	<-idleConnsClosed
-	log.Info().Msg("Graceful shutdown finished.")
-	return nil
+	return d.serve(func(addr string) error {
+		return d.Router.Echo.Start(addr)
+	})
 }
 
 type TLSConfig struct {
@@ -73,28 +99,55 @@ type TLSConfig struct {
 }
 
 func (d *Draken) ServeTLS(tlsConfig TLSConfig) error {
-	// Channel to listen for termination signals
+	return d.serve(func(addr string) error {
+		return d.Router.Echo.StartTLS(addr, tlsConfig.CertFile, tlsConfig.KeyFile)
+	})
+}
+
+// serve runs the OnStart hooks, starts the http server and shuts the app
+// down gracefully once SIGINT or SIGTERM is received.
+func (d *Draken) serve(start func(addr string) error) error {
+	if err := d.Lifecycle.Start(d.options.Context); err != nil {
+		return err
+	}
+
+	// Listen for OS interrupt or termination signals
+	sigint := make(chan os.Signal, 1)
+	signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigint)
+
 	idleConnsClosed := make(chan struct{})
+	startFailed := make(chan struct{})
 
 	go func() {
-		// Listen for OS interrupt or termination signals
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
-		<-sigint
+		select {
+		case <-sigint:
+		case <-startFailed:
+			return
+		}
 
 		log.Debug().Msg("Graceful shutdown initiated.")
 		// Initiate graceful shutdown with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), d.Config.Server.ShutdownTimeout)
 		defer cancel()
 
-		if err := d.Router.Echo.Shutdown(ctx); err != nil {
-			log.Error().Err(errorx.IllegalState.New("shutdown failed")).Send()
+		if err := d.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Graceful shutdown failed.")
 		}
 		close(idleConnsClosed)
 	}()
 
+	d.configureServer(d.Router.Echo.Server)
+	d.configureServer(d.Router.Echo.TLSServer)
+
 	log.Info().Msgf("Listening on port %d", d.Config.Server.Port)
-	if err := d.Router.Echo.StartTLS(fmt.Sprintf(":%d", d.Config.Server.Port), tlsConfig.CertFile, tlsConfig.KeyFile); err != http.ErrServerClosed {
+	if err := start(fmt.Sprintf(":%d", d.Config.Server.Port)); err != http.ErrServerClosed {
+		close(startFailed)
+		ctx, cancel := context.WithTimeout(context.Background(), d.Config.Server.ShutdownTimeout)
+		defer cancel()
+		if stopErr := d.Lifecycle.Stop(ctx); stopErr != nil {
+			log.Error().Err(stopErr).Msg("Stopping components failed.")
+		}
 		return err
 	}
 
@@ -103,6 +156,39 @@ func (d *Draken) ServeTLS(tlsConfig TLSConfig) error {
 	return nil
 }
 
+// configureServer applies the timeouts and header limit of the config.
+func (d *Draken) configureServer(s *http.Server) {
+	s.ReadTimeout = d.Config.Server.ReadTimeout
+	s.WriteTimeout = d.Config.Server.WriteTimeout
+	s.IdleTimeout = d.Config.Server.IdleTimeout
+	s.MaxHeaderBytes = d.Config.Server.MaxHeaderBytes
+}
+
+// Shutdown fails readiness for the configured shutdown delay, stops the
+// http server from accepting new requests, waits for the running ones and
+// then stops all components in reverse start order.
+func (d *Draken) Shutdown(ctx context.Context) error {
+	d.Health.SetDraining(true)
+	if delay := d.Config.Server.ShutdownDelay; delay > 0 {
+		log.Debug().Msgf("Draining for %s before shutting down...", delay)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+
+	var errs []error
+	if d.Router != nil {
+		if err := d.Router.Echo.Shutdown(ctx); err != nil {
+			errs = append(errs, errorx.IllegalState.Wrap(err, "shutting down the http server failed"))
+		}
+	}
+	if err := d.Lifecycle.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errorx.DecorateMany("shutdown failed", errs...)
+}
+
 func DrakenHandler(w http.ResponseWriter, r *http.Request) (*Response, *Request) {
 	return GetResponse(w), GetRequest(r)
 }
@@ -0,0 +1,91 @@
+package draken
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	RequestId string            `json:"request_id,omitempty"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	Stack     string            `json:"stack,omitempty"`
+}
+
+// HTTPErrorHandler renders errors returned by handlers as problem
+// responses. Details of server errors and stack traces are only exposed
+// in debug mode outside of the prod environment.
+func (d *Draken) HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	debug := d.Config.Debug && d.Config.Environment != EnvironmentProd
+	problem := NewProblem(err, debug)
+	problem.Instance = c.Request().URL.Path
+	if id, ok := c.Get(string(ContextKeyRequestId)).(string); ok {
+		problem.RequestId = id
+	}
+
+	if problem.Status >= http.StatusInternalServerError {
+		log.Error().Err(err).Str("request_id", problem.RequestId).Msg("Request failed.")
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(problem.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, ProblemContentType)
+		writeErr = c.JSON(problem.Status, problem)
+	}
+	if writeErr != nil {
+		log.Error().Err(writeErr).Msg("Writing the error response failed.")
+	}
+}
+
+// NewProblem converts an error into a problem. With debug the detail of
+// server errors and the stack trace are included.
+func NewProblem(err error, debug bool) Problem {
+	status := ErrorStatus(err)
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		problem.Detail = fmt.Sprint(he.Message)
+	} else if ex := errorx.Cast(err); ex != nil {
+		if ex.Type().RootNamespace().Key() == Errors.Key() {
+			problem.Type = "urn:" + ex.Type().FullName()
+		}
+		problem.Detail = ex.Message()
+		if fields, ok := errorx.ExtractProperty(err, PropertyFieldErrors); ok {
+			problem.Errors, _ = fields.(map[string]string)
+		}
+	}
+
+	if status >= http.StatusInternalServerError && !debug {
+		problem.Detail = ""
+	}
+	if debug {
+		if status >= http.StatusInternalServerError && problem.Detail == "" {
+			problem.Detail = err.Error()
+		}
+		problem.Stack = fmt.Sprintf("%+v", err)
+	}
+	return problem
+}
@@ -0,0 +1,122 @@
+package draken
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+)
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"not found", ErrNotFound.New("missing"), http.StatusNotFound},
+		{"validation", NewValidationError(map[string]string{"name": "is required"}), http.StatusBadRequest},
+		{"unauthorized", ErrUnauthorized.New("no token"), http.StatusUnauthorized},
+		{"forbidden", ErrForbidden.New("denied"), http.StatusForbidden},
+		{"conflict", ErrConflict.New("taken"), http.StatusConflict},
+		{"rate limited", ErrRateLimited.New("slow down"), http.StatusTooManyRequests},
+		{"internal", ErrInternal.New("broken"), http.StatusInternalServerError},
+		{"timeout", ErrTimeout.New("slow"), http.StatusGatewayTimeout},
+		{"unavailable", ErrUnavailable.New("down"), http.StatusServiceUnavailable},
+		{"connection failed", ErrConnectionFailed.New("down"), http.StatusServiceUnavailable},
+		{"invalid config", ErrInvalidConfig.New("bad"), http.StatusInternalServerError},
+		{"subtype", ErrNotFound.NewSubtype("order").New("missing"), http.StatusNotFound},
+		{"decorated", errorx.Decorate(ErrForbidden.New("denied"), "loading"), http.StatusForbidden},
+		{"wrapped", ErrTimeout.Wrap(ErrNotFound.New("missing"), "slow"), http.StatusGatewayTimeout},
+		{"illegal argument", errorx.IllegalArgument.New("bad"), http.StatusBadRequest},
+		{"illegal format", errorx.IllegalFormat.New("bad"), http.StatusBadRequest},
+		{"errorx not found trait", errorx.DataUnavailable.NewSubtype("x", errorx.NotFound()).New("missing"), http.StatusNotFound},
+		{"errorx timeout", errorx.TimeoutElapsed.New("slow"), http.StatusGatewayTimeout},
+		{"echo", echo.NewHTTPError(http.StatusTeapot, "short and stout"), http.StatusTeapot},
+		{"plain", errors.New("boom"), http.StatusInternalServerError},
+	} {
+		if got := ErrorStatus(tt.err); got != tt.want {
+			t.Errorf("%s: ErrorStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		err    error
+		debug  bool
+		env    Environment
+		status int
+		typ    string
+		detail string
+		errors map[string]string
+		stack  bool
+	}{
+		{name: "not found", err: ErrNotFound.New("no item 1"), status: http.StatusNotFound, typ: "urn:draken.not_found", detail: "no item 1"},
+		{name: "subtype", err: ErrNotFound.NewSubtype("order").New("no order 1"), status: http.StatusNotFound, typ: "urn:draken.not_found.order", detail: "no order 1"},
+		{name: "validation", err: NewValidationError(map[string]string{"name": "is required"}), status: http.StatusBadRequest,
+			typ: "urn:draken.validation", detail: "request validation failed", errors: map[string]string{"name": "is required"}},
+		{name: "echo", err: echo.NewHTTPError(http.StatusMethodNotAllowed, "use POST"), status: http.StatusMethodNotAllowed, typ: "about:blank", detail: "use POST"},
+		{name: "foreign errorx", err: errorx.IllegalArgument.New("bad id"), status: http.StatusBadRequest, typ: "about:blank", detail: "bad id"},
+		{name: "internal hidden", err: ErrInternal.New("password=secret"), status: http.StatusInternalServerError, typ: "urn:draken.internal"},
+		{name: "plain hidden", err: errors.New("password=secret"), status: http.StatusInternalServerError, typ: "about:blank"},
+		{name: "echo 5xx hidden", err: echo.NewHTTPError(http.StatusBadGateway, "password=secret"), status: http.StatusBadGateway, typ: "about:blank"},
+		{name: "internal debug", err: ErrInternal.New("password=secret"), debug: true, status: http.StatusInternalServerError,
+			typ: "urn:draken.internal", detail: "password=secret", stack: true},
+		{name: "plain debug", err: errors.New("password=secret"), debug: true, status: http.StatusInternalServerError,
+			typ: "about:blank", detail: "password=secret", stack: true},
+		{name: "debug in prod", err: ErrInternal.New("password=secret"), debug: true, env: EnvironmentProd,
+			status: http.StatusInternalServerError, typ: "urn:draken.internal"},
+	} {
+		d := &Draken{}
+		d.Config.Debug = tt.debug
+		d.Config.Environment = tt.env
+		d.CreateRouter()
+		d.Router.Get("/fail", func(c echo.Context) error { return tt.err })
+
+		rec := httptest.NewRecorder()
+		d.Router.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if ct := rec.Header().Get(echo.HeaderContentType); ct != ProblemContentType {
+			t.Errorf("%s: content type = %s, want %s", tt.name, ct, ProblemContentType)
+		}
+		var problem Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: %v in %s", tt.name, err, rec.Body)
+		}
+		if problem.Status != tt.status || problem.Type != tt.typ || problem.Title != http.StatusText(tt.status) || problem.Instance != "/fail" {
+			t.Errorf("%s: problem = %+v", tt.name, problem)
+		}
+		if problem.Detail != tt.detail {
+			t.Errorf("%s: detail = %q, want %q", tt.name, problem.Detail, tt.detail)
+		}
+		if len(problem.Errors) != len(tt.errors) || problem.Errors["name"] != tt.errors["name"] {
+			t.Errorf("%s: errors = %v, want %v", tt.name, problem.Errors, tt.errors)
+		}
+		if (problem.Stack != "") != tt.stack {
+			t.Errorf("%s: stack = %q", tt.name, problem.Stack)
+		}
+		if !tt.debug && strings.Contains(rec.Body.String(), "secret") {
+			t.Errorf("%s: the response exposes the error: %s", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestHTTPErrorHandlerHead(t *testing.T) {
+	d := &Draken{}
+	d.CreateRouter()
+	d.Router.Echo.HEAD("/fail", func(c echo.Context) error { return ErrForbidden.New("denied") })
+
+	rec := httptest.NewRecorder()
+	d.Router.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/fail", nil))
+	if rec.Code != http.StatusForbidden || rec.Body.Len() != 0 {
+		t.Errorf("HEAD = %d with body %q, want %d without a body", rec.Code, rec.Body, http.StatusForbidden)
+	}
+}
@@ -1,3 +1,90 @@
 package draken
 
-var ()
+import (
+	"errors"
+	"net/http"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// Errors is the root namespace of all errors created by draken.
+	Errors = errorx.NewNamespace("draken")
+
+	// ErrInvalidConfig is returned by Config.Validate.
+	ErrInvalidConfig = Errors.NewType("invalid_config")
+
+	// ErrConnectionFailed is returned when a backend could not be reached
+	// within its RetryPolicy.
+	ErrConnectionFailed = Errors.NewType("connection_failed", errorx.Temporary())
+
+	// The following types are rendered as problem responses by the
+	// HTTPErrorHandler. Apps can derive their own errors from them with
+	// NewSubtype and keep the status mapping, e.g.
+	// ErrOrderNotFound = draken.ErrNotFound.NewSubtype("order").
+	ErrNotFound     = Errors.NewType("not_found", errorx.NotFound())
+	ErrValidation   = Errors.NewType("validation")
+	ErrUnauthorized = Errors.NewType("unauthorized")
+	ErrForbidden    = Errors.NewType("forbidden")
+	ErrConflict     = Errors.NewType("conflict", errorx.Duplicate())
+	ErrRateLimited  = Errors.NewType("rate_limited", errorx.Temporary())
+	ErrInternal     = Errors.NewType("internal")
+	ErrTimeout      = Errors.NewType("timeout", errorx.Timeout())
+	ErrUnavailable  = Errors.NewType("unavailable", errorx.Temporary())
+
+	// PropertyConfigProblems holds the []ConfigProblem of an ErrInvalidConfig.
+	PropertyConfigProblems = errorx.RegisterProperty("config_problems")
+
+	// PropertyFieldErrors holds a map[string]string of field names to
+	// messages, it is rendered as the errors member of a problem response.
+	PropertyFieldErrors = errorx.RegisterProperty("field_errors")
+)
+
+var errorStatuses = []struct {
+	t      *errorx.Type
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrValidation, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrConflict, http.StatusConflict},
+	{ErrRateLimited, http.StatusTooManyRequests},
+	{ErrInternal, http.StatusInternalServerError},
+	{ErrTimeout, http.StatusGatewayTimeout},
+	{ErrUnavailable, http.StatusServiceUnavailable},
+	{ErrConnectionFailed, http.StatusServiceUnavailable},
+	{errorx.IllegalArgument, http.StatusBadRequest},
+	{errorx.IllegalFormat, http.StatusBadRequest},
+}
+
+// ErrorStatus returns the http status an error is rendered with. Unknown
+// errors are internal server errors.
+func ErrorStatus(err error) int {
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code
+	}
+
+	for _, s := range errorStatuses {
+		if errorx.IsOfType(err, s.t) {
+			return s.status
+		}
+	}
+
+	switch {
+	case errorx.IsNotFound(err):
+		return http.StatusNotFound
+	case errorx.IsDuplicate(err):
+		return http.StatusConflict
+	case errorx.IsTimeout(err):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// NewValidationError creates an ErrValidation carrying per field messages.
+func NewValidationError(fields map[string]string) *errorx.Error {
+	return ErrValidation.New("request validation failed").WithProperty(PropertyFieldErrors, fields)
+}
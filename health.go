@@ -0,0 +1,159 @@
+package draken
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// HealthCheck reports an error if the checked dependency is unhealthy.
+type HealthCheck func(ctx context.Context) error
+
+const (
+	HealthStatusOk       = "ok"
+	HealthStatusFail     = "fail"
+	HealthStatusDraining = "draining"
+)
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// Health is the registry of liveness and readiness checks served on the
+// heartbeat endpoints.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	draining  atomic.Bool
+	// Timeout bounds every single check.
+	Timeout time.Duration
+}
+
+// HealthReport is the JSON body returned by the liveness and readiness routes.
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+type HealthCheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func NewHealth(timeout time.Duration) *Health {
+	return &Health{Timeout: timeout}
+}
+
+// AddLivenessCheck registers a check that fails /livez. Liveness checks
+// should only fail if the process cannot recover without a restart.
+func (h *Health) AddLivenessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck registers a check that fails /readyz, e.g. a ping
+// of a dependency the app cannot serve requests without.
+func (h *Health) AddReadinessCheck(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+// SetDraining makes readiness fail so load balancers stop routing traffic
+// to the app during graceful shutdown.
+func (h *Health) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Liveness runs all liveness checks.
+func (h *Health) Liveness(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	checks := append([]namedCheck(nil), h.liveness...)
+	h.mu.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// Readiness runs all readiness checks and fails while draining.
+func (h *Health) Readiness(ctx context.Context) HealthReport {
+	h.mu.RLock()
+	checks := append([]namedCheck(nil), h.readiness...)
+	h.mu.RUnlock()
+
+	report := h.run(ctx, checks)
+	if h.Draining() {
+		report.Status = HealthStatusDraining
+	}
+	return report
+}
+
+// run executes the checks concurrently, each bounded by the timeout.
+func (h *Health) run(ctx context.Context, checks []namedCheck) HealthReport {
+	report := HealthReport{
+		Status: HealthStatusOk,
+		Checks: make(map[string]HealthCheckResult, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx := ctx
+			if h.Timeout > 0 {
+				var cancel context.CancelFunc
+				checkCtx, cancel = context.WithTimeout(ctx, h.Timeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			err := c.check(checkCtx)
+			result := HealthCheckResult{
+				Status:    HealthStatusOk,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = HealthStatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.name] = result
+			if err != nil {
+				report.Status = HealthStatusFail
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func (h *Health) LivenessRoute(ctx echo.Context) error {
+	return healthResponse(ctx, h.Liveness(ctx.Request().Context()))
+}
+
+func (h *Health) ReadinessRoute(ctx echo.Context) error {
+	return healthResponse(ctx, h.Readiness(ctx.Request().Context()))
+}
+
+func healthResponse(ctx echo.Context, report HealthReport) error {
+	status := http.StatusOK
+	if report.Status != HealthStatusOk {
+		status = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(status, report)
+}
@@ -0,0 +1,77 @@
+package draken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func healthRoute(t *testing.T, route echo.HandlerFunc) (int, HealthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	if err := route(c); err != nil {
+		t.Fatal(err)
+	}
+	var report HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, report
+}
+
+func TestHealthReadiness(t *testing.T) {
+	h := NewHealth(10 * time.Millisecond)
+	ok := func(context.Context) error { return nil }
+	h.AddReadinessCheck("storage", ok)
+	h.AddReadinessCheck("cache", ok)
+
+	if code, report := healthRoute(t, h.ReadinessRoute); code != http.StatusOK || report.Status != HealthStatusOk || len(report.Checks) != 2 {
+		t.Errorf("readiness = %d %+v, want ok", code, report)
+	}
+
+	// a hanging check fails once the timeout of the check elapsed
+	h.AddReadinessCheck("objectStorage", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	code, report := healthRoute(t, h.ReadinessRoute)
+	if code != http.StatusServiceUnavailable || report.Status != HealthStatusFail {
+		t.Errorf("readiness with a hanging check = %d %+v, want fail", code, report)
+	}
+	if c := report.Checks["objectStorage"]; c.Status != HealthStatusFail || c.Error == "" {
+		t.Errorf("objectStorage check = %+v, want fail with the error", c)
+	}
+	if c := report.Checks["storage"]; c.Status != HealthStatusOk {
+		t.Errorf("storage check = %+v, want ok", c)
+	}
+
+	h = NewHealth(time.Second)
+	h.AddReadinessCheck("storage", ok)
+	h.SetDraining(true)
+	if code, report := healthRoute(t, h.ReadinessRoute); code != http.StatusServiceUnavailable || report.Status != HealthStatusDraining {
+		t.Errorf("readiness while draining = %d %+v, want draining", code, report)
+	}
+}
+
+func TestHealthLiveness(t *testing.T) {
+	h := NewHealth(time.Second)
+	h.AddReadinessCheck("storage", func(context.Context) error { return errors.New("down") })
+	h.SetDraining(true)
+	// readiness checks and draining do not fail liveness
+	if code, report := healthRoute(t, h.LivenessRoute); code != http.StatusOK || report.Status != HealthStatusOk || len(report.Checks) != 0 {
+		t.Errorf("liveness = %d %+v, want ok", code, report)
+	}
+
+	h.AddLivenessCheck("deadlock", func(context.Context) error { return errors.New("stuck") })
+	code, report := healthRoute(t, h.LivenessRoute)
+	if code != http.StatusServiceUnavailable || report.Checks["deadlock"].Error != "stuck" {
+		t.Errorf("liveness with a failing check = %d %+v, want fail", code, report)
+	}
+}
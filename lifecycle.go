@@ -0,0 +1,110 @@
+package draken
+
+import (
+	"context"
+	"sync"
+
+	"github.com/joomcode/errorx"
+	"github.com/rs/zerolog/log"
+)
+
+// Hook is run while starting or stopping the app.
+type Hook func(ctx context.Context) error
+
+type component struct {
+	name    string
+	start   Hook
+	stop    Hook
+	started bool
+}
+
+// Lifecycle starts components in the order they were appended and stops
+// the started ones in reverse order.
+type Lifecycle struct {
+	mu         sync.Mutex
+	components []*component
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Append registers a component, either hook may be nil.
+func (l *Lifecycle) Append(name string, start, stop Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.components = append(l.components, &component{name: name, start: start, stop: stop})
+}
+
+// Start starts every component that has not been started yet. If a
+// component fails, the already started ones are stopped again. Hooks may
+// append further components, they are started in the same call.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	for c := l.next(); c != nil; c = l.next() {
+		log.Debug().Str("component", c.name).Msgf("Starting %s...", c.name)
+		if c.start != nil {
+			if err := c.start(ctx); err != nil {
+				if stopErr := l.Stop(ctx); stopErr != nil {
+					log.Error().Err(stopErr).Msg("Rolling back started components failed.")
+				}
+				return errorx.Decorate(err, "starting %s failed", c.name)
+			}
+		}
+
+		l.mu.Lock()
+		c.started = true
+		l.mu.Unlock()
+	}
+	return nil
+}
+
+// next returns the first component that has not been started yet.
+func (l *Lifecycle) next() *component {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.components {
+		if !c.started {
+			return c
+		}
+	}
+	return nil
+}
+
+// Stop stops all started components in reverse order. Every component is
+// stopped even if a previous one failed, the errors are returned together.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	var started []*component
+	for _, c := range l.components {
+		if c.started {
+			started = append(started, c)
+			c.started = false
+		}
+	}
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(started) - 1; i >= 0; i-- {
+		c := started[i]
+		log.Debug().Str("component", c.name).Msgf("Stopping %s...", c.name)
+		if c.stop == nil {
+			continue
+		}
+		if err := c.stop(ctx); err != nil {
+			errs = append(errs, errorx.Decorate(err, "stopping %s failed", c.name))
+		}
+	}
+	return errorx.DecorateMany("stopping components failed", errs...)
+}
+
+// OnStart runs hook when the app is served, after storage, cache and object
+// storage are available.
+func (d *Draken) OnStart(name string, hook Hook) {
+	d.Lifecycle.Append(name, hook, nil)
+}
+
+// OnStop runs hook during graceful shutdown, after the http server stopped
+// accepting requests and before storage, cache and object storage are closed.
+func (d *Draken) OnStop(name string, hook Hook) {
+	d.Lifecycle.Append(name, nil, hook)
+}
@@ -0,0 +1,115 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+// recordingLifecycle appends components that record their start and stop
+// to calls, the component named in fail fails to start.
+func recordingLifecycle(calls *[]string, fail string, names ...string) *Lifecycle {
+	l := NewLifecycle()
+	for _, name := range names {
+		l.Append(name, func(context.Context) error {
+			if name == fail {
+				return errors.New("broken")
+			}
+			*calls = append(*calls, "start "+name)
+			return nil
+		}, func(context.Context) error {
+			*calls = append(*calls, "stop "+name)
+			return nil
+		})
+	}
+	return l
+}
+
+func TestLifecycleOrder(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	l := recordingLifecycle(&calls, "", "storage", "cache")
+	l.Append("app", func(context.Context) error {
+		// components appended by a hook are started in the same call
+		l.Append("late", nil, func(context.Context) error {
+			calls = append(calls, "stop late")
+			return nil
+		})
+		return nil
+	}, nil)
+
+	if err := l.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start storage", "start cache", "stop late", "stop cache", "stop storage"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	calls = nil
+	if err := l.Stop(ctx); err != nil || len(calls) != 0 {
+		t.Errorf("a second Stop = %v and stopped %v again", err, calls)
+	}
+}
+
+func TestLifecycleStartFailure(t *testing.T) {
+	var calls []string
+	l := recordingLifecycle(&calls, "cache", "storage", "objectStorage", "cache", "auth")
+
+	if err := l.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded with a failing component")
+	}
+	want := []string{"start storage", "start objectStorage", "stop objectStorage", "stop storage"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestLifecycleStopsEveryComponent(t *testing.T) {
+	ctx := context.Background()
+	var stopped []string
+	l := NewLifecycle()
+	for _, name := range []string{"a", "b", "c"} {
+		l.Append(name, nil, func(context.Context) error {
+			stopped = append(stopped, name)
+			if name != "a" {
+				return errors.New(name + " is stuck")
+			}
+			return nil
+		})
+	}
+	if err := l.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	err := l.Stop(ctx)
+	if err == nil || !slices.Equal(stopped, []string{"c", "b", "a"}) {
+		t.Errorf("Stop = %v and stopped %v, want an error after stopping c, b and a", err, stopped)
+	}
+}
+
+func TestServeStopsComponentsWhenTheServerFails(t *testing.T) {
+	var calls []string
+	d := &Draken{Lifecycle: recordingLifecycle(&calls, "", "storage"), Health: NewHealth(time.Second)}
+	d.options.Context = context.Background()
+	d.Config.Server.ShutdownTimeout = time.Second
+	d.CreateRouter()
+
+	listenErr := errorx.ExternalError.New("address already in use")
+	if err := d.serve(func(string) error { return listenErr }); err != listenErr {
+		t.Errorf("serve = %v, want the error of the server", err)
+	}
+	if want := []string{"start storage", "stop storage"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
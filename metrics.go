@@ -0,0 +1,175 @@
+package draken
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "draken"
+
+// Metrics holds the prometheus registry served on the metrics endpoint
+// and the collectors draken fills itself.
+type Metrics struct {
+	Registry      *prometheus.Registry
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	inFlight      *prometheus.GaugeVec
+	timeouts      *prometheus.CounterVec
+	r2LimiterWait prometheus.Histogram
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of handled http requests.",
+		}, []string{"route", "method", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of handled http requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of http requests currently being handled.",
+		}, []string{"route", "method"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_timeouts_total",
+			Help:      "Number of http requests whose deadline elapsed.",
+		}, []string{"route", "method"}),
+		r2LimiterWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "r2",
+			Name:      "limiter_wait_seconds",
+			Help:      "Time spent waiting for the r2 rate limiter.",
+			Buckets:   []float64{.001, .01, .1, .5, 1, 2.5, 5, 10, 30, 60},
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.timeouts,
+		m.r2LimiterWait,
+	)
+	return m
+}
+
+// Register adds app specific collectors to the registry. Collectors that
+// are already registered, e.g. after a restart of the lifecycle, are skipped.
+func (m *Metrics) Register(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		err := m.Registry.Register(c)
+		var are prometheus.AlreadyRegisteredError
+		if err != nil && !errors.As(err, &are) {
+			log.Error().Err(err).Msg("Registering metrics collector failed.")
+		}
+	}
+}
+
+// Handler serves the registry in the prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// ObserveR2LimiterWait records the time a request waited for the r2 limiter.
+func (m *Metrics) ObserveR2LimiterWait(d time.Duration) {
+	m.r2LimiterWait.Observe(d.Seconds())
+}
+
+// ObserveTimeout counts a request whose deadline elapsed.
+func (m *Metrics) ObserveTimeout(route, method string) {
+	m.timeouts.WithLabelValues(route, method).Inc()
+}
+
+// MetricsMiddleware counts requests and observes their latency labelled by
+// the route template, method and status.
+func MetricsMiddleware(m *Metrics) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+
+			inFlight := m.inFlight.WithLabelValues(route, method)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			start := time.Now()
+			err = next(c)
+
+			status := strconv.Itoa(responseStatus(c, err))
+			m.requests.WithLabelValues(route, method, status).Inc()
+			m.duration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// redisPoolCollector exports the connection pool stats of a redis client.
+type redisPoolCollector struct {
+	client     *redis.Client
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newRedisPoolCollector(client *redis.Client) *redisPoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "redis_pool", name), help, nil, nil)
+	}
+	return &redisPoolCollector{
+		client:     client,
+		hits:       desc("hits_total", "Number of times a free connection was found in the pool."),
+		misses:     desc("misses_total", "Number of times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "Number of times a wait for a connection timed out."),
+		totalConns: desc("total_connections", "Number of connections in the pool."),
+		idleConns:  desc("idle_connections", "Number of idle connections in the pool."),
+		staleConns: desc("stale_connections_total", "Number of stale connections removed from the pool."),
+	}
+}
+
+func (c *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+	ch <- c.totalConns
+	ch <- c.idleConns
+	ch <- c.staleConns
+}
+
+func (c *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
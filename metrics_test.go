@@ -0,0 +1,40 @@
+package draken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareStatus(t *testing.T) {
+	d := &Draken{Metrics: NewMetrics()}
+	d.CreateRouter()
+	handled := 0
+	handler := d.Router.Echo.HTTPErrorHandler
+	d.Router.Echo.HTTPErrorHandler = func(err error, c echo.Context) {
+		handled++
+		handler(err, c)
+	}
+	d.Router.Middleware(MetricsMiddleware(d.Metrics))
+	d.Router.Get("/items/:id", func(c echo.Context) error {
+		if c.Param("id") == "missing" {
+			return ErrNotFound.New("no item")
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	for _, id := range []string{"1", "missing"} {
+		d.Router.Echo.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/"+id, nil))
+	}
+	for status, want := range map[string]float64{"204": 1, "404": 1, "200": 0} {
+		if got := testutil.ToFloat64(d.Metrics.requests.WithLabelValues("/items/:id", http.MethodGet, status)); got != want {
+			t.Errorf("requests with status %s = %v, want %v", status, got, want)
+		}
+	}
+	if handled != 1 {
+		t.Errorf("the error was handled %d times, want once", handled)
+	}
+}
@@ -25,17 +25,35 @@ func (r *Router) EssentialMiddlewares() {
 	}
 
 	r.Middleware(RequestIdMiddleware())
+	if r.Draken.Tracing != nil {
+		r.Middleware(TracingMiddleware(r.Draken.Tracing))
+	}
 	r.Middleware(middleware.GzipWithConfig(middleware.GzipConfig{
 		Level: 5,
 	}))
 	r.Middleware(LoggerMiddleware(log.Logger))
+	if r.Draken.Metrics != nil {
+		r.Middleware(MetricsMiddleware(r.Draken.Metrics))
+	}
 	r.Middleware(middleware.Recover())
+	r.Middleware(TimeoutMiddleware(r.Draken.Config.Server.RequestTimeout, r.Draken.Metrics))
+	if r.Draken.Auth != nil {
+		r.Middleware(AuthMiddleware(r.Draken.Auth))
+	}
+	r.applyRateLimits()
 	if r.Draken.Config.Server.Security {
 		r.Middleware(middleware.Secure())
 	}
 
 	if r.Draken.Config.Server.Heartbeat.Enabled {
-		r.Get(r.Draken.Config.Server.Heartbeat.Endpoint, HeartbeatRoute)
+		heartbeat := r.Draken.Config.Server.Heartbeat
+		r.Get(heartbeat.Endpoint, HeartbeatRoute)
+		r.Get(heartbeat.Liveness, r.Draken.Health.LivenessRoute)
+		r.Get(heartbeat.Readiness, r.Draken.Health.ReadinessRoute)
+	}
+
+	if r.Draken.Metrics != nil {
+		r.Get(r.Draken.Config.Server.Metrics.Endpoint, echo.WrapHandler(r.Draken.Metrics.Handler()))
 	}
 }
 
@@ -61,6 +79,16 @@ func WebserverMiddleware() echo.MiddlewareFunc {
 	}
 }
 
+// responseStatus returns the status the response of c is sent with. A
+// returned error is only rendered by the error handler after all
+// middlewares, its status is derived from the error until then.
+func responseStatus(c echo.Context, err error) int {
+	if err != nil && !c.Response().Committed {
+		return ErrorStatus(err)
+	}
+	return c.Response().Status
+}
+
 func LoggerMiddleware(l zerolog.Logger) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -71,13 +99,18 @@ func LoggerMiddleware(l zerolog.Logger) echo.MiddlewareFunc {
 
 			res := c.Response()
 			req := c.Request()
+			status := responseStatus(c, err)
+
+			e := l.Info()
+			if traceId := TraceId(req.Context()); traceId != "" {
+				e = e.Str("trace_id", traceId)
+			}
 
-			l.Info().
-				Str("method", req.Method).
+			e.Str("method", req.Method).
 				Str("url", req.URL.String()).
 				Str("proto", req.Proto).
 				Str("remote", CloudflareCompatibleIP(c)).
-				Int("status", res.Status).
+				Int("status", status).
 				Int64("bytes", res.Size).
 				Dur("duration", time.Since(start)).
 				Any("request_id", c.Get(string(ContextKeyRequestId))).
@@ -86,7 +119,7 @@ func LoggerMiddleware(l zerolog.Logger) echo.MiddlewareFunc {
 					req.URL.String(),
 					req.Proto,
 					c.RealIP(),
-					res.Status,
+					status,
 					res.Size,
 					time.Since(start))
 
@@ -0,0 +1,297 @@
+package draken
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+const migrationLockPoll = time.Second
+
+// Migrator applies the migrations registered with WithMigrations and
+// WithMigrationFiles to the storage.
+type Migrator struct {
+	*migrate.Migrator
+	Config MigrationConfig
+}
+
+func (d *Draken) initMigrations(ctx context.Context, storage Storage) error {
+	migrations := d.options.Migrations
+	if migrations == nil {
+		migrations = migrate.NewMigrations(migrate.WithMigrationsDirectory(d.Config.Storage.Migrations.Directory))
+	}
+	for _, fsys := range d.options.MigrationFiles {
+		if err := migrations.Discover(fsys); err != nil {
+			return ErrInvalidConfig.Wrap(err, "discovering the migration files failed")
+		}
+	}
+	// the tables of draken sort before the timestamped migrations of the app
+	if auth := d.Config.Auth; auth.Enabled {
+		if auth.ApiKeys.Enabled {
+			migrations.Add(tableMigration("00000000000001", "draken_api_keys", (*ApiKey)(nil)))
+		}
+		if auth.Policy.Source == PolicySourceStorage {
+			migrations.Add(tableMigration("00000000000002", "draken_role_permissions", (*RolePermission)(nil)))
+		}
+	}
+
+	d.Migrator = NewMigrator(storage, migrations, d.Config.Storage.Migrations)
+	if !d.Config.Storage.Migrations.AutoApply {
+		return nil
+	}
+	if len(migrations.Sorted()) == 0 {
+		log.Debug().Msgf("No migrations registered, skipping auto apply...")
+		return nil
+	}
+	_, err := d.Migrator.Up(ctx)
+	return err
+}
+
+// tableMigration creates the table of model. Older versions created the
+// tables at startup, so existing tables are kept.
+func tableMigration(name, comment string, model any) migrate.Migration {
+	return migrate.Migration{
+		Name:    name,
+		Comment: comment,
+		Up: func(ctx context.Context, db *bun.DB, _ any) error {
+			_, err := db.NewCreateTable().Model(model).IfNotExists().Exec(ctx)
+			return err
+		},
+		Down: func(ctx context.Context, db *bun.DB, _ any) error {
+			_, err := db.NewDropTable().Model(model).IfExists().Exec(ctx)
+			return err
+		},
+	}
+}
+
+// requireTable fails with ErrInvalidConfig if the table of model was not
+// migrated yet.
+func requireTable(ctx context.Context, storage Storage, model any, migration string) error {
+	if _, err := storage.Bun().NewSelect().Model(model).Limit(1).Exists(ctx); err != nil {
+		return ErrInvalidConfig.Wrap(err, "the table of the %s migration is missing, apply the migrations first", migration)
+	}
+	return nil
+}
+
+func NewMigrator(storage Storage, migrations *migrate.Migrations, cfg MigrationConfig) *Migrator {
+	return &Migrator{
+		Migrator: migrate.NewMigrator(storage.Bun(), migrations,
+			migrate.WithTableName(cfg.Table),
+			migrate.WithLocksTableName(cfg.LocksTable),
+			migrate.WithMarkAppliedOnSuccess(true),
+		),
+		Config: cfg,
+	}
+}
+
+// Up applies all pending migrations as one group.
+func (m *Migrator) Up(ctx context.Context) (*migrate.MigrationGroup, error) {
+	log.Debug().Msgf("Applying migrations...")
+	var group *migrate.MigrationGroup
+	err := m.locked(ctx, func() (err error) {
+		group, err = m.Migrate(ctx)
+		return err
+	})
+	if err != nil {
+		return group, errorx.Decorate(err, "applying the migrations failed")
+	}
+
+	if group.IsZero() {
+		log.Info().Msgf("Database is up to date.")
+	} else {
+		log.Info().Msgf("Applied migration group %d: %s.", group.ID, group.Migrations)
+	}
+	return group, nil
+}
+
+// Down rolls back the last applied migration group.
+func (m *Migrator) Down(ctx context.Context) (*migrate.MigrationGroup, error) {
+	log.Debug().Msgf("Rolling back migrations...")
+	var group *migrate.MigrationGroup
+	err := m.locked(ctx, func() (err error) {
+		group, err = m.Rollback(ctx)
+		return err
+	})
+	if err != nil {
+		return group, errorx.Decorate(err, "rolling back the migrations failed")
+	}
+
+	if group.IsZero() {
+		log.Info().Msgf("No migration group to roll back.")
+	} else {
+		log.Info().Msgf("Rolled back migration group %d: %s.", group.ID, group.Migrations)
+	}
+	return group, nil
+}
+
+// Status returns all registered migrations, applied ones have a group id.
+func (m *Migrator) Status(ctx context.Context) (migrate.MigrationSlice, error) {
+	if err := m.Init(ctx); err != nil {
+		return nil, ErrInternal.Wrap(err, "creating the migration tables failed")
+	}
+	ms, err := m.MigrationsWithStatus(ctx)
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "reading the migration status failed")
+	}
+	return ms, nil
+}
+
+// Create writes empty up and down migrations to the migrations directory,
+// as sql files or as a go file.
+func (m *Migrator) Create(ctx context.Context, name string, sql bool) ([]*migrate.MigrationFile, error) {
+	if err := os.MkdirAll(m.Config.Directory, 0755); err != nil {
+		return nil, ErrInternal.Wrap(err, "creating the migrations directory %s failed", m.Config.Directory)
+	}
+	creator := migrate.NewMigrator(m.DB(), migrate.NewMigrations(migrate.WithMigrationsDirectory(m.Config.Directory)))
+	name = strings.ReplaceAll(name, " ", "_")
+
+	if sql {
+		files, err := creator.CreateTxSQLMigrations(ctx, name)
+		if err != nil {
+			return nil, ErrInternal.Wrap(err, "creating the migration %s failed", name)
+		}
+		return files, nil
+	}
+	file, err := creator.CreateGoMigration(ctx, name)
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "creating the migration %s failed", name)
+	}
+	return []*migrate.MigrationFile{file}, nil
+}
+
+// locked runs fn while holding the migration lock. Replicas starting at the
+// same time wait for the lock instead of failing, the lock is given up
+// after the lock timeout and other database errors fail immediately. A
+// lock left behind by a crashed process can be released with the migrate
+// unlock command.
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	if err := m.Init(ctx); err != nil {
+		return ErrInternal.Wrap(err, "creating the migration tables failed")
+	}
+
+	deadline := time.Now().Add(m.Config.LockTimeout)
+	for {
+		err := m.Lock(ctx)
+		if err == nil {
+			break
+		}
+		// the lock row exists, or sqlite is busy with the run of another
+		// process
+		if !isUniqueViolation(err) && !RetryableTxError(err) {
+			return ErrInternal.Wrap(err, "acquiring the migration lock failed")
+		}
+		if time.Now().After(deadline) {
+			return ErrTimeout.Wrap(err, "acquiring the migration lock timed out after %s", m.Config.LockTimeout)
+		}
+		log.Debug().Msgf("Migrations are locked by another process, waiting...")
+		select {
+		case <-ctx.Done():
+			return ErrTimeout.Wrap(ctx.Err(), "waiting for the migration lock was cancelled")
+		case <-time.After(migrationLockPoll):
+		}
+	}
+	defer func() {
+		// the context may be cancelled, the lock has to be released anyway
+		if err := m.Unlock(context.WithoutCancel(ctx)); err != nil {
+			log.Error().Err(err).Msg("Releasing the migration lock failed.")
+		}
+	}()
+
+	if err := fn(); err != nil {
+		return ErrInternal.Wrap(err, "running the migrations failed")
+	}
+	return nil
+}
+
+const migrateUsage = `usage: migrate <command>
+
+commands:
+  up                  apply all pending migrations
+  down                roll back the last migration group
+  status              list the migrations and whether they are applied
+  create <name>       create a go migration
+  create-sql <name>   create up and down sql migrations
+  unlock              release a migration lock left by a crashed process
+`
+
+// CLI runs the command given in args, e.g. os.Args[1:], and reports
+// whether there was one. Without a command the app should be served.
+//
+//	if ok, err := d.CLI(os.Args[1:]); ok { ... }
+func (d *Draken) CLI(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "migrate":
+		if d.Migrator == nil {
+			return true, ErrInvalidConfig.New("migrations require draken.storage.enabled")
+		}
+		return true, d.Migrator.run(d.options.Context, os.Stdout, args[1:])
+	}
+	return true, errorx.IllegalArgument.New("unknown command %s, expected migrate", args[0])
+}
+
+func (m *Migrator) run(ctx context.Context, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(out, migrateUsage)
+		return errorx.IllegalArgument.New("missing migrate command")
+	}
+
+	switch args[0] {
+	case "up":
+		_, err := m.Up(ctx)
+		return err
+	case "down":
+		_, err := m.Down(ctx)
+		return err
+	case "status":
+		ms, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tGROUP\tMIGRATED AT")
+		for _, migration := range ms {
+			if migration.IsApplied() {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", migration.String(), migration.GroupID, migration.MigratedAt.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, "%s\t-\tpending\n", migration.String())
+			}
+		}
+		return w.Flush()
+	case "create", "create-sql":
+		if len(args) < 2 {
+			return errorx.IllegalArgument.New("missing migration name")
+		}
+		files, err := m.Create(ctx, strings.Join(args[1:], "_"), args[0] == "create-sql")
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Fprintf(out, "created %s\n", f.Path)
+		}
+		return nil
+	case "unlock":
+		if err := m.Init(ctx); err != nil {
+			return ErrInternal.Wrap(err, "creating the migration tables failed")
+		}
+		if err := m.Unlock(ctx); err != nil {
+			return ErrInternal.Wrap(err, "releasing the migration lock failed")
+		}
+		log.Info().Msgf("Released the migration lock.")
+		return nil
+	}
+	fmt.Fprint(out, migrateUsage)
+	return errorx.IllegalArgument.New("unknown migrate command %s", args[0])
+}
@@ -0,0 +1,228 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+)
+
+func testMigrationConfig() MigrationConfig {
+	return MigrationConfig{
+		Directory:   "migrations",
+		Table:       "draken_migrations",
+		LocksTable:  "draken_migration_locks",
+		LockTimeout: 5 * time.Second,
+	}
+}
+
+// newTestMigrations registers a migration creating the items table and
+// counts how often it was applied.
+func newTestMigrations(t *testing.T, applied *atomic.Int32) *migrate.Migrations {
+	t.Helper()
+	migrations := migrate.NewMigrations()
+	migrations.Add(migrate.Migration{
+		Name: "20260101000000",
+		Up: func(ctx context.Context, db *bun.DB, _ any) error {
+			applied.Add(1)
+			_, err := db.NewCreateTable().Model((*testItem)(nil)).Exec(ctx)
+			return err
+		},
+		Down: func(ctx context.Context, db *bun.DB, _ any) error {
+			_, err := db.NewDropTable().Model((*testItem)(nil)).Exec(ctx)
+			return err
+		},
+	})
+	return migrations
+}
+
+func TestMigrateCommands(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	var applied atomic.Int32
+	m := NewMigrator(storage, newTestMigrations(t, &applied), testMigrationConfig())
+
+	status := func() string {
+		var out bytes.Buffer
+		if err := m.run(ctx, &out, []string{"status"}); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if s := status(); !strings.Contains(s, "pending") {
+		t.Errorf("status before up:\n%s", s)
+	}
+
+	for range 2 {
+		if err := m.run(ctx, &bytes.Buffer{}, []string{"up"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if applied.Load() != 1 {
+		t.Errorf("the migration was applied %d times, want once", applied.Load())
+	}
+	if s := status(); strings.Contains(s, "pending") {
+		t.Errorf("status after up:\n%s", s)
+	}
+	if _, err := storage.Client.NewSelect().Model((*testItem)(nil)).Count(ctx); err != nil {
+		t.Errorf("the table of the migration is missing: %v", err)
+	}
+
+	if err := m.run(ctx, &bytes.Buffer{}, []string{"down"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); !strings.Contains(s, "pending") {
+		t.Errorf("status after down:\n%s", s)
+	}
+	if _, err := storage.Client.NewSelect().Model((*testItem)(nil)).Count(ctx); err == nil {
+		t.Error("the table of the migration was not dropped")
+	}
+
+	if err := m.run(ctx, &bytes.Buffer{}, []string{"sideways"}); !errorx.IsOfType(err, errorx.IllegalArgument) {
+		t.Errorf("unknown command = %v, want IllegalArgument", err)
+	}
+}
+
+func TestConcurrentUpWaitsForTheLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "main.db")
+	open := func() *SqlDatabase {
+		storage, err := NewSqlite(ctx, SqliteConfig{
+			Path:        path,
+			JournalMode: "WAL",
+			Synchronous: "NORMAL",
+			BusyTimeout: 5 * time.Second,
+		}, DefaultRetryPolicy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(storage.Stop)
+		return storage
+	}
+	var applied atomic.Int32
+	first := NewMigrator(open(), newTestMigrations(t, &applied), testMigrationConfig())
+	second := NewMigrator(open(), newTestMigrations(t, &applied), testMigrationConfig())
+
+	// the first replica holds the lock while the second one starts
+	if err := first.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := second.Up(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Up did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Up after the lock was released: %v", err)
+	}
+	if _, err := first.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if applied.Load() != 1 {
+		t.Errorf("the migration was applied %d times, want once", applied.Load())
+	}
+}
+
+func TestLockErrorsAreNotRetried(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	cfg := testMigrationConfig()
+	cfg.LockTimeout = time.Minute
+	// inserting the lock fails as the locks table is a view
+	if _, err := storage.Client.ExecContext(ctx, "CREATE VIEW "+cfg.LocksTable+" AS SELECT 1 AS id, '' AS table_name"); err != nil {
+		t.Fatal(err)
+	}
+	var applied atomic.Int32
+	m := NewMigrator(storage, newTestMigrations(t, &applied), cfg)
+
+	start := time.Now()
+	_, err := m.Up(ctx)
+	if !errorx.IsOfType(err, ErrInternal) {
+		t.Errorf("Up = %v, want ErrInternal", err)
+	}
+	if waited := time.Since(start); waited > migrationLockPoll {
+		t.Errorf("Up waited %s for a lock that cannot be taken", waited)
+	}
+}
+
+func TestFailedMigrationClosesTheStorage(t *testing.T) {
+	migrations := migrate.NewMigrations()
+	migrations.Add(migrate.Migration{
+		Name: "20260101000000",
+		Up: func(ctx context.Context, db *bun.DB, _ any) error {
+			return errors.New("broken migration")
+		},
+		Down: func(ctx context.Context, db *bun.DB, _ any) error { return nil },
+	})
+	d := &Draken{options: Options{Migrations: migrations}}
+	d.Config.Storage = StorageConfig{
+		Enabled: true,
+		Type:    StorageTypeSqlite,
+		Sqlite:  SqliteConfig{Path: ":memory:", JournalMode: "MEMORY", Synchronous: "NORMAL"},
+		Retry:   DefaultRetryPolicy(),
+		TxRetry: DefaultTxRetryPolicy(),
+	}
+	d.Config.Storage.Migrations = testMigrationConfig()
+	d.Config.Storage.Migrations.AutoApply = true
+
+	if err := d.initStorage(context.Background()); err == nil {
+		t.Fatal("initStorage succeeded with a failing migration")
+	}
+	if d.Storage != nil || d.Migrator != nil {
+		t.Error("the storage of a failed startup was kept")
+	}
+}
+
+func TestDrakenTablesAreMigrated(t *testing.T) {
+	ctx := context.Background()
+	d := &Draken{Health: NewHealth(time.Second)}
+	d.Config.Storage = StorageConfig{
+		Enabled: true,
+		Type:    StorageTypeSqlite,
+		Sqlite:  SqliteConfig{Path: ":memory:", JournalMode: "MEMORY", Synchronous: "NORMAL"},
+		Retry:   DefaultRetryPolicy(),
+		TxRetry: DefaultTxRetryPolicy(),
+	}
+	d.Config.Storage.Migrations = testMigrationConfig()
+	d.Config.Auth.Enabled = true
+	d.Config.Auth.ApiKeys.Enabled = true
+	d.Config.Auth.Policy.Source = PolicySourceStorage
+
+	if err := d.initStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Storage.Stop)
+	if _, err := NewApiKeyStore(ctx, d.Storage); !errorx.IsOfType(err, ErrInvalidConfig) {
+		t.Errorf("NewApiKeyStore before the migrations = %v, want ErrInvalidConfig", err)
+	}
+
+	if _, err := d.Migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewApiKeyStore(ctx, d.Storage); err != nil {
+		t.Errorf("NewApiKeyStore after the migrations: %v", err)
+	}
+	if _, err := NewPolicyStore(ctx, d.Storage); err != nil {
+		t.Errorf("NewPolicyStore after the migrations: %v", err)
+	}
+}
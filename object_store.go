@@ -0,0 +1,24 @@
+package draken
+
+import (
+	"context"
+	"io"
+)
+
+// ObjectStore is the object storage used by the app. R2 implements it for
+// every S3 compatible provider, tests can replace it with a
+// MemoryObjectStore passed to WithObjectStore.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body io.Reader, opts ...ObjectOption) (*ObjectInfo, error)
+	Get(ctx context.Context, key string, opts ...ObjectOption) (*Object, error)
+	Head(ctx context.Context, key string, opts ...ObjectOption) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string, opts ...ObjectOption) error
+	DeleteMany(ctx context.Context, keys []string, opts ...ObjectOption) error
+	Copy(ctx context.Context, srcKey, dstKey string, opts ...ObjectOption) (*ObjectInfo, error)
+	List(ctx context.Context, list ListOptions, opts ...ObjectOption) (*ObjectPage, error)
+	ListAll(ctx context.Context, prefix string, fn func(ObjectInfo) error, opts ...ObjectOption) error
+	Ping(ctx context.Context) error
+	Stop()
+}
+
+var _ ObjectStore = (*R2)(nil)
@@ -0,0 +1,233 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxObjectListLimit is the maximum number of keys per list page of S3.
+const maxObjectListLimit = 1000
+
+// MemoryObjectStore keeps objects in memory. It behaves like an S3 bucket
+// for the ObjectStore methods and is meant for tests and local development,
+// see WithObjectStore.
+type MemoryObjectStore struct {
+	// Bucket is used by all operations that do not name a bucket.
+	Bucket string
+
+	mu      sync.RWMutex
+	buckets map[string]map[string]memoryObject
+}
+
+type memoryObject struct {
+	info ObjectInfo
+	data []byte
+}
+
+var _ ObjectStore = (*MemoryObjectStore)(nil)
+
+func NewMemoryObjectStore(bucket string) *MemoryObjectStore {
+	return &MemoryObjectStore{
+		Bucket:  bucket,
+		buckets: make(map[string]map[string]memoryObject),
+	}
+}
+
+func (m *MemoryObjectStore) Put(ctx context.Context, key string, body io.Reader, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "putting %s failed", key)
+	}
+
+	sum := md5.Sum(data)
+	info := ObjectInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		ContentType:  o.contentType,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+		Metadata:     maps.Clone(o.metadata),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	objects, ok := m.buckets[o.bucket]
+	if !ok {
+		objects = make(map[string]memoryObject)
+		m.buckets[o.bucket] = objects
+	}
+	objects[key] = memoryObject{info: info, data: data}
+	return &info, nil
+}
+
+func (m *MemoryObjectStore) Get(ctx context.Context, key string, opts ...ObjectOption) (*Object, error) {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := m.object(o.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := obj.data
+	if o.byteRange != "" {
+		var start, end int64
+		if _, err := fmt.Sscanf(o.byteRange, "bytes=%d-%d", &start, &end); err != nil || start > end || start >= int64(len(data)) {
+			return nil, ErrValidation.New("invalid range %s for %s", o.byteRange, key)
+		}
+		data = data[start:min(end+1, int64(len(data)))]
+	}
+
+	info := obj.info
+	info.Size = int64(len(data))
+	return &Object{ObjectInfo: info, Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *MemoryObjectStore) Head(ctx context.Context, key string, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := m.object(o.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.info, nil
+}
+
+// Delete removes the object at key, deleting a missing key is not an error.
+func (m *MemoryObjectStore) Delete(ctx context.Context, key string, opts ...ObjectOption) error {
+	return m.DeleteMany(ctx, []string{key}, opts...)
+}
+
+func (m *MemoryObjectStore) DeleteMany(ctx context.Context, keys []string, opts ...ObjectOption) error {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.buckets[o.bucket], key)
+	}
+	return nil
+}
+
+// Copy copies srcKey to dstKey. Use FromBucket to copy across buckets.
+func (m *MemoryObjectStore) Copy(ctx context.Context, srcKey, dstKey string, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	src, err := m.object(o.sourceBucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+
+	copyOpts := []ObjectOption{InBucket(o.bucket), WithContentType(src.info.ContentType), WithMetadata(src.info.Metadata)}
+	if o.metadata != nil || o.contentType != "" {
+		copyOpts = append(copyOpts, WithContentType(o.contentType), WithMetadata(o.metadata))
+	}
+	return m.Put(ctx, dstKey, bytes.NewReader(src.data), copyOpts...)
+}
+
+// List returns a single page of objects in key order. The cursor is the
+// last key or prefix of the previous page.
+func (m *MemoryObjectStore) List(ctx context.Context, list ListOptions, opts ...ObjectOption) (*ObjectPage, error) {
+	o, err := applyObjectOptions(m.Bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	limit := int(list.Limit)
+	if limit <= 0 || limit > maxObjectListLimit {
+		limit = maxObjectListLimit
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objects := m.buckets[o.bucket]
+	keys := slices.Sorted(maps.Keys(objects))
+
+	page := &ObjectPage{Objects: []ObjectInfo{}, Prefixes: []string{}}
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, list.Prefix) || (list.Cursor != "" && key <= list.Cursor) {
+			continue
+		}
+		// keys below a prefix of the previous page were already reported
+		prefix := ""
+		if list.Delimiter != "" {
+			if i := strings.Index(key[len(list.Prefix):], list.Delimiter); i >= 0 {
+				prefix = key[:len(list.Prefix)+i+len(list.Delimiter)]
+			}
+		}
+		if prefix != "" && (prefix == list.Cursor || prefix == last) {
+			continue
+		}
+
+		if len(page.Objects)+len(page.Prefixes) == limit {
+			page.NextCursor = last
+			break
+		}
+		if prefix != "" {
+			page.Prefixes = append(page.Prefixes, prefix)
+			last = prefix
+		} else {
+			page.Objects = append(page.Objects, objects[key].info)
+			last = key
+		}
+	}
+	return page, nil
+}
+
+// ListAll calls fn for every object below prefix.
+func (m *MemoryObjectStore) ListAll(ctx context.Context, prefix string, fn func(ObjectInfo) error, opts ...ObjectOption) error {
+	list := ListOptions{Prefix: prefix}
+	for {
+		page, err := m.List(ctx, list, opts...)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		list.Cursor = page.NextCursor
+	}
+}
+
+func (m *MemoryObjectStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryObjectStore) Stop() {}
+
+// object returns the object at key in bucket, ErrNotFound if it is missing.
+func (m *MemoryObjectStore) object(bucket, key string) (memoryObject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.buckets[bucket][key]
+	if !ok {
+		return memoryObject{}, ErrNotFound.New("object %s does not exist", key)
+	}
+	return obj, nil
+}
@@ -0,0 +1,64 @@
+package draken
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/joomcode/errorx"
+)
+
+func TestMemoryObjectStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryObjectStore("bucket")
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d"} {
+		if _, err := store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	list := ListOptions{Delimiter: "/", Limit: 2}
+	for {
+		page, err := store.List(ctx, list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page.Prefixes...)
+		for _, obj := range page.Objects {
+			got = append(got, obj.Key)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		list.Cursor = page.NextCursor
+	}
+	if strings.Join(got, ",") != "a/,b/,c,d" {
+		t.Errorf("listed %v", got)
+	}
+}
+
+func TestMemoryObjectStoreCopy(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryObjectStore("bucket")
+	if _, err := store.Put(ctx, "src", strings.NewReader("data"), WithContentType("text/plain")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Copy(ctx, "src", "dst", InBucket("other"), FromBucket("bucket")); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := store.Get(ctx, "dst", InBucket("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(obj.Body)
+	if string(body) != "data" || obj.ContentType != "text/plain" {
+		t.Errorf("copy is %q %+v", body, obj.ObjectInfo)
+	}
+
+	if _, err := store.Copy(ctx, "missing", "dst"); !errorx.IsOfType(err, ErrNotFound) {
+		t.Errorf("copying a missing object = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,121 @@
+package draken
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/uptrace/bun/migrate"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ConfigPathEnv is the environment variable that overrides the config file path.
+const ConfigPathEnv = "DRAKEN_CONFIG"
+
+const (
+	defaultConfigPath = ".config/draken.yaml"
+	defaultEnvFile    = ".env"
+)
+
+// Options controls where a Draken app loads its configuration from.
+type Options struct {
+	// ConfigPath is the base yaml config file. It is overridden by the
+	// DRAKEN_CONFIG environment variable when set.
+	ConfigPath string
+	// ConfigReader replaces the base config file when set.
+	ConfigReader io.Reader
+	// Overlays are additional yaml files merged on top of the base config
+	// in the given order, after the per-environment overlay.
+	Overlays []string
+	// EnvFiles are loaded into the environment before the config is read.
+	EnvFiles []string
+	// Context bounds the connection attempts made while creating the app.
+	Context context.Context
+	// SpanExporter replaces the configured trace exporter.
+	SpanExporter sdktrace.SpanExporter
+	// ObjectStore replaces the configured object storage client.
+	ObjectStore ObjectStore
+	// Migrations holds the go migrations of the app.
+	Migrations *migrate.Migrations
+	// MigrationFiles hold sql migrations, e.g. an embed.FS.
+	MigrationFiles []fs.FS
+}
+
+type Option func(*Options)
+
+func defaultOptions() Options {
+	return Options{
+		ConfigPath: defaultConfigPath,
+		EnvFiles:   []string{defaultEnvFile},
+		Context:    context.Background(),
+	}
+}
+
+// WithConfigPath sets the path of the base config file.
+func WithConfigPath(path string) Option {
+	return func(o *Options) {
+		o.ConfigPath = path
+	}
+}
+
+// WithConfigReader reads the base config from r instead of a file.
+func WithConfigReader(r io.Reader) Option {
+	return func(o *Options) {
+		o.ConfigReader = r
+	}
+}
+
+// WithConfigOverlay merges the given yaml files on top of the base config.
+func WithConfigOverlay(paths ...string) Option {
+	return func(o *Options) {
+		o.Overlays = append(o.Overlays, paths...)
+	}
+}
+
+// WithEnvFile replaces the default .env file with the given files.
+func WithEnvFile(paths ...string) Option {
+	return func(o *Options) {
+		o.EnvFiles = paths
+	}
+}
+
+// WithContext bounds the startup of the app, cancelling ctx aborts
+// pending connection retries.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
+// WithSpanExporter exports spans synchronously to exp instead of the
+// configured exporter, e.g. to a tracetest.InMemoryExporter in tests.
+// Tracing still has to be enabled in the config.
+func WithSpanExporter(exp sdktrace.SpanExporter) Option {
+	return func(o *Options) {
+		o.SpanExporter = exp
+	}
+}
+
+// WithObjectStore uses store instead of creating a client from the object
+// storage config, e.g. to point tests at a local fake.
+func WithObjectStore(store ObjectStore) Option {
+	return func(o *Options) {
+		o.ObjectStore = store
+	}
+}
+
+// WithMigrations registers the go migrations of the app, they are run
+// together with the sql migrations of WithMigrationFiles.
+func WithMigrations(migrations *migrate.Migrations) Option {
+	return func(o *Options) {
+		o.Migrations = migrations
+	}
+}
+
+// WithMigrationFiles registers the sql migrations found in fsys, files are
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+func WithMigrationFiles(fsys fs.FS) Option {
+	return func(o *Options) {
+		o.MigrationFiles = append(o.MigrationFiles, fsys)
+	}
+}
@@ -9,57 +9,161 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/joomcode/errorx"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/time/rate"
 )
 
 type R2 struct {
 	AccountId       string
+	Bucket          string
 	AccessKeyId     string
 	AccessKeySecret string
 	Limiter         *rate.Limiter
 	Client          *s3.Client
-	Context         context.Context
-	Cancel          context.CancelFunc
+	// Context is the lifetime of the client, the object operations use the
+	// context passed by the caller, e.g. RequestCtx in handlers.
+	Context context.Context
+	Cancel  context.CancelFunc
+	metrics *Metrics
 }
 
-func NewR2(accountId, accessKeyId, accessKeySecret string) *R2 {
-	config.WithRequestChecksumCalculation(0)
-	config.WithResponseChecksumValidation(0)
-	endpoint := fmt.Sprintf("https://%s.eu.r2.cloudflarestorage.com", accountId)
+// NewR2 creates a client for the configured object storage provider. The
+// type keeps its name from when only R2 was supported, it works with any S3
+// compatible store.
+func NewR2(cfg ObjectStorageConfig, optFns ...func(*s3.Options)) (*R2, error) {
+	endpoint, region := objectStorageEndpoint(cfg)
+
+	checksum := aws.RequestChecksumCalculationWhenSupported
+	validation := aws.ResponseChecksumValidationWhenSupported
+	if cfg.Checksum == ChecksumWhenRequired {
+		checksum = aws.RequestChecksumCalculationWhenRequired
+		validation = aws.ResponseChecksumValidationWhenRequired
+	}
+	loadOpts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithRequestChecksumCalculation(checksum),
+		config.WithResponseChecksumValidation(validation),
+	}
+	// s3 without keys uses the default credential chain, e.g. an instance role
+	if cfg.AccessKeyId != "" || cfg.Provider != ObjectStorageProviderS3 {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyId, cfg.AccessKeySecret, "")))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKeySecret, "")),
-		config.WithRegion("auto"),
-	)
+	awsCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
-		log.Fatal().Err(err).Msgf("Failed to load R2 configuration for endpoint %s", endpoint)
+		cancel()
+		return nil, errorx.InitializationFailed.Wrap(err, "failed to load object storage configuration for endpoint %s", endpoint)
 	}
 
-	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(endpoint)
-	})
+	optFns = append([]func(*s3.Options){func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		// custom endpoints such as MinIO usually do not resolve bucket subdomains
+		o.UsePathStyle = cfg.PathStyle || cfg.Provider == ObjectStorageProviderCustom
+	}}, optFns...)
+	client := s3.NewFromConfig(awsCfg, optFns...)
 
-	log.Debug().Msgf("R2 configuration loaded for endpoint %s", endpoint)
+	if endpoint == "" {
+		endpoint = "aws default"
+	}
+	log.Debug().Msgf("Object storage configuration loaded for endpoint %s in region %s", endpoint, region)
 	return &R2{
-		AccountId:       accountId,
-		AccessKeyId:     accessKeyId,
-		AccessKeySecret: accessKeySecret,
+		AccountId:       cfg.AccountId,
+		Bucket:          cfg.Bucket,
+		AccessKeyId:     cfg.AccessKeyId,
+		AccessKeySecret: cfg.AccessKeySecret,
 		// 90 requests per minute
 		Limiter: rate.NewLimiter(rate.Every(1*time.Minute/90), 90),
 		Client:  client,
 		Context: ctx,
 		Cancel:  cancel,
+	}, nil
+}
+
+// objectStorageEndpoint returns the endpoint and region of the provider.
+// An empty endpoint leaves the resolution to the aws sdk.
+func objectStorageEndpoint(cfg ObjectStorageConfig) (string, string) {
+	region := cfg.Region
+	if cfg.Provider == ObjectStorageProviderR2 || region == "" {
+		region = "auto"
+	}
+	if cfg.Endpoint != "" {
+		return cfg.Endpoint, region
+	}
+
+	switch cfg.Provider {
+	case ObjectStorageProviderR2:
+		switch cfg.Jurisdiction {
+		case R2JurisdictionEU:
+			return fmt.Sprintf("https://%s.eu.r2.cloudflarestorage.com", cfg.AccountId), region
+		case R2JurisdictionFedRAMP:
+			return fmt.Sprintf("https://%s.fedramp.r2.cloudflarestorage.com", cfg.AccountId), region
+		default:
+			return fmt.Sprintf("https://%s.r2.cloudflarestorage.com", cfg.AccountId), region
+		}
+	default:
+		return "", region
+	}
+}
+
+func (d *Draken) initObjectStore(ctx context.Context) error {
+	if d.options.ObjectStore != nil {
+		log.Debug().Msgf("Using the object store passed in the options.")
+		d.ObjectStore = d.options.ObjectStore
+		d.Health.AddReadinessCheck("objectStorage", d.ObjectStore.Ping)
+		return nil
+	}
+	if !d.Config.ObjectStorage.Enabled {
+		log.Debug().Msgf("Object storage is disabled in the config, skipping...")
+		return nil
+	}
+	log.Debug().Msgf("Initializing object storage...")
+	var optFns []func(*s3.Options)
+	if d.Tracing != nil {
+		optFns = append(optFns, d.Tracing.S3Middleware())
+	}
+	r2, err := NewR2(d.Config.ObjectStorage, optFns...)
+	if err != nil {
+		return err
+	}
+	r2.metrics = d.Metrics
+	d.R2 = r2
+	d.ObjectStore = r2
+	d.Health.AddReadinessCheck("objectStorage", d.ObjectStore.Ping)
+	log.Info().Msgf("Object storage initialized.")
+	return nil
+}
+
+// Wait blocks until the limiter permits another request and records how
+// long it waited.
+func (r *R2) Wait(ctx context.Context) error {
+	start := time.Now()
+	err := r.Limiter.Wait(ctx)
+	if r.metrics != nil {
+		r.metrics.ObserveR2LimiterWait(time.Since(start))
+	}
+	return err
+}
+
+func (r *R2) Stop() {
+	if r.Cancel != nil {
+		r.Cancel()
 	}
+	log.Info().Msgf("R2 client stopped.")
 }
 
-func (d *Draken) initR2() {
-	if !d.Config.R2.Enabled {
-		log.Debug().Msgf("R2 is disabled in the config, skipping...")
-		return
+// Ping checks that the configured bucket is reachable, without a bucket
+// it checks that the credentials can list buckets. It bypasses the limiter
+// so that health probes do not eat into the request budget.
+func (r *R2) Ping(ctx context.Context) error {
+	if r.Bucket == "" {
+		_, err := r.Client.ListBuckets(ctx, &s3.ListBucketsInput{MaxBuckets: aws.Int32(1)})
+		return err
 	}
-	log.Debug().Msgf("Initializing R2...")
-	d.R2 = NewR2(d.Config.R2.AccountId, d.Config.R2.AccessKeyId, d.Config.R2.AccessKeySecret)
-	log.Info().Msgf("R2 initialized.")
+	_, err := r.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(r.Bucket)})
+	return err
 }
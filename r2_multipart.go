@@ -0,0 +1,436 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts, except for the last one.
+	minPartSize        = 5 << 20
+	defaultPartSize    = 16 << 20
+	maxParts           = 10000
+	defaultConcurrency = 4
+)
+
+// WithPartSize sets the part size of Upload and Download, defaults to 16 MiB.
+// Upload holds up to concurrency parts in memory.
+func WithPartSize(size int64) ObjectOption {
+	return func(o *objectOptions) {
+		o.partSize = size
+	}
+}
+
+// WithConcurrency sets how many parts Upload and Download transfer in
+// parallel, defaults to 4.
+func WithConcurrency(n int) ObjectOption {
+	return func(o *objectOptions) {
+		o.concurrency = n
+	}
+}
+
+// ResumeUpload continues the multipart upload with the given id. Parts
+// that were already uploaded are read from the body but not sent again, so
+// the body has to start at the beginning of the object.
+func ResumeUpload(uploadId string) ObjectOption {
+	return func(o *objectOptions) {
+		o.uploadId = uploadId
+	}
+}
+
+// OnUploadId is called with the id of a newly created multipart upload,
+// save it to resume the upload after an interruption.
+func OnUploadId(fn func(uploadId string)) ObjectOption {
+	return func(o *objectOptions) {
+		o.onUploadId = fn
+	}
+}
+
+// transferOptions resolves the part size and concurrency.
+func (r *R2) transferOptions(opts []ObjectOption) (*objectOptions, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.partSize == 0 {
+		o.partSize = defaultPartSize
+	}
+	if o.partSize < minPartSize {
+		return nil, ErrValidation.New("part size must be at least %d bytes, got %d", minPartSize, o.partSize)
+	}
+	if o.contentLength != nil {
+		// grow the parts so that the object fits into the part limit
+		if size := (*o.contentLength + maxParts - 1) / maxParts; size > o.partSize {
+			o.partSize = size
+		}
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = defaultConcurrency
+	}
+	return o, nil
+}
+
+// Upload streams body to key as a multipart upload, uploading up to
+// concurrency parts in parallel. Bodies smaller than one part are put in a
+// single request. A failed upload is not aborted, it can be continued with
+// ResumeUpload or removed by AbortOrphaned.
+func (r *R2) Upload(ctx context.Context, key string, body io.Reader, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := r.transferOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, o.partSize)
+	n, err := io.ReadFull(body, buf)
+	last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !last {
+		return nil, ErrInternal.Wrap(err, "reading the first part of %s failed", key)
+	}
+	if last && o.uploadId == "" {
+		return r.Put(ctx, key, bytes.NewReader(buf[:n]), append(opts, WithContentLength(int64(n)))...)
+	}
+
+	uploadId := o.uploadId
+	uploaded := map[int32]types.Part{}
+	if uploadId == "" {
+		if err := r.Wait(ctx); err != nil {
+			return nil, r2Error(err, "creating multipart upload of %s failed", key)
+		}
+		out, err := r.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:       aws.String(o.bucket),
+			Key:          aws.String(key),
+			ContentType:  optionalString(o.contentType),
+			CacheControl: optionalString(o.cacheControl),
+			Metadata:     o.metadata,
+		})
+		if err != nil {
+			return nil, r2Error(err, "creating multipart upload of %s failed", key)
+		}
+		uploadId = aws.ToString(out.UploadId)
+		if o.onUploadId != nil {
+			o.onUploadId(uploadId)
+		}
+		log.Debug().Str("key", key).Str("upload_id", uploadId).Msg("Created multipart upload.")
+	} else {
+		if uploaded, err = r.uploadedParts(ctx, o.bucket, key, uploadId); err != nil {
+			return nil, err
+		}
+		log.Debug().Str("key", key).Str("upload_id", uploadId).Msgf("Resuming multipart upload with %d uploaded parts.", len(uploaded))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		completed []types.CompletedPart
+		firstErr  error
+		size      int64
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	complete := func(number int32, etag string) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed = append(completed, types.CompletedPart{PartNumber: aws.Int32(number), ETag: aws.String(etag)})
+	}
+
+	// the buffers double as the semaphore bounding the parallel uploads
+	buffers := make(chan []byte, o.concurrency)
+	allocated := 1
+	for number := int32(1); ; number++ {
+		if number > maxParts {
+			fail(ErrValidation.New("%s exceeds %d parts, increase the part size", key, maxParts))
+			break
+		}
+		data := buf[:n]
+		size += int64(n)
+
+		if part, ok := uploaded[number]; ok && samePart(part, data) {
+			complete(number, aws.ToString(part.ETag))
+			buffers <- buf
+		} else {
+			wg.Add(1)
+			go func(number int32, data, buf []byte) {
+				defer wg.Done()
+				defer func() { buffers <- buf }()
+				etag, err := r.uploadPart(ctx, o.bucket, key, uploadId, number, data)
+				if err != nil {
+					fail(err)
+					return
+				}
+				complete(number, etag)
+			}(number, data, buf)
+		}
+		if last {
+			break
+		}
+
+		if allocated < o.concurrency && len(buffers) == 0 {
+			buf = make([]byte, o.partSize)
+			allocated++
+		} else {
+			select {
+			case buf = <-buffers:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		n, err = io.ReadFull(body, buf)
+		last = errors.Is(err, io.ErrUnexpectedEOF)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !last {
+			fail(ErrInternal.Wrap(err, "reading part %d of %s failed", number+1, key))
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = r2Error(ctx.Err(), "uploading %s failed", key)
+	}
+	if firstErr != nil {
+		log.Error().Err(firstErr).Str("key", key).Str("upload_id", uploadId).Msg("Multipart upload failed, it can be resumed with its upload id.")
+		return nil, firstErr
+	}
+
+	slices.SortFunc(completed, func(a, b types.CompletedPart) int {
+		return int(aws.ToInt32(a.PartNumber) - aws.ToInt32(b.PartNumber))
+	})
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "completing multipart upload of %s failed", key)
+	}
+	out, err := r.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(o.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return nil, r2Error(err, "completing multipart upload of %s failed", key)
+	}
+
+	log.Debug().Str("key", key).Str("upload_id", uploadId).Msgf("Completed multipart upload with %d parts.", len(completed))
+	return &ObjectInfo{
+		Key:         key,
+		Size:        size,
+		ETag:        aws.ToString(out.ETag),
+		ContentType: o.contentType,
+		Metadata:    o.metadata,
+	}, nil
+}
+
+func (r *R2) uploadPart(ctx context.Context, bucket, key, uploadId string, number int32, data []byte) (string, error) {
+	if err := r.Wait(ctx); err != nil {
+		return "", r2Error(err, "uploading part %d of %s failed", number, key)
+	}
+	out, err := r.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadId),
+		PartNumber:    aws.Int32(number),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return "", r2Error(err, "uploading part %d of %s failed", number, key)
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// uploadedParts lists the parts of an unfinished upload by part number.
+func (r *R2) uploadedParts(ctx context.Context, bucket, key, uploadId string) (map[int32]types.Part, error) {
+	parts := map[int32]types.Part{}
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	}
+	for {
+		if err := r.Wait(ctx); err != nil {
+			return nil, r2Error(err, "listing parts of %s failed", key)
+		}
+		out, err := r.Client.ListParts(ctx, input)
+		if err != nil {
+			return nil, r2Error(err, "listing parts of %s failed", key)
+		}
+		for _, p := range out.Parts {
+			parts[aws.ToInt32(p.PartNumber)] = p
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return parts, nil
+		}
+		input.PartNumberMarker = out.NextPartNumberMarker
+	}
+}
+
+// samePart reports whether an uploaded part holds data. Part etags are the
+// hex md5 of the part.
+func samePart(part types.Part, data []byte) bool {
+	if aws.ToInt64(part.Size) != int64(len(data)) {
+		return false
+	}
+	sum := md5.Sum(data)
+	return strings.Trim(aws.ToString(part.ETag), `"`) == hex.EncodeToString(sum[:])
+}
+
+// Abort removes an unfinished multipart upload and its parts.
+func (r *R2) Abort(ctx context.Context, key, uploadId string, opts ...ObjectOption) error {
+	o, err := r.options(opts)
+	if err != nil {
+		return err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return r2Error(err, "aborting multipart upload of %s failed", key)
+	}
+	_, err = r.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(o.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+	if err != nil {
+		return r2Error(err, "aborting multipart upload of %s failed", key)
+	}
+	return nil
+}
+
+// AbortOrphaned aborts all multipart uploads started more than olderThan
+// ago and returns how many were aborted. Unfinished uploads are billed
+// until they are aborted.
+func (r *R2) AbortOrphaned(ctx context.Context, olderThan time.Duration, opts ...ObjectOption) (int, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	aborted := 0
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(o.bucket)}
+	for {
+		if err := r.Wait(ctx); err != nil {
+			return aborted, r2Error(err, "listing multipart uploads failed")
+		}
+		out, err := r.Client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return aborted, r2Error(err, "listing multipart uploads failed")
+		}
+		for _, u := range out.Uploads {
+			if !aws.ToTime(u.Initiated).Before(cutoff) {
+				continue
+			}
+			if err := r.Abort(ctx, aws.ToString(u.Key), aws.ToString(u.UploadId), InBucket(o.bucket)); err != nil {
+				return aborted, err
+			}
+			aborted++
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		input.KeyMarker = out.NextKeyMarker
+		input.UploadIdMarker = out.NextUploadIdMarker
+	}
+
+	log.Info().Msgf("Aborted %d orphaned multipart uploads.", aborted)
+	return aborted, nil
+}
+
+// Download writes the object at key to w, fetching up to concurrency
+// ranges of part size in parallel. It fails with ErrConflict if the object
+// changes during the download.
+func (r *R2) Download(ctx context.Context, key string, w io.WriterAt, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := r.transferOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	info, err := r.Head(ctx, key, InBucket(o.bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	offsets := make(chan int64)
+	for range min(o.concurrency, int(info.Size/o.partSize)+1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range offsets {
+				end := min(start+o.partSize, info.Size) - 1
+				if err := r.downloadRange(ctx, key, w, start, end, info.ETag, InBucket(o.bucket)); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	for start := int64(0); start < info.Size; start += o.partSize {
+		select {
+		case offsets <- start:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(offsets)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, r2Error(err, "downloading %s failed", key)
+	}
+	return info, nil
+}
+
+func (r *R2) downloadRange(ctx context.Context, key string, w io.WriterAt, start, end int64, etag string, opts ...ObjectOption) error {
+	obj, err := r.Get(ctx, key, append(opts, WithRange(start, end))...)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	if obj.ETag != etag {
+		return ErrConflict.New("%s changed during the download", key)
+	}
+	if _, err := io.Copy(io.NewOffsetWriter(w, start), obj.Body); err != nil {
+		return r2Error(err, "downloading bytes %d-%d of %s failed", start, end, key)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+// testObject returns size random bytes.
+func testObject(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(rand.IntN(256))
+	}
+	return data
+}
+
+func getObject(t *testing.T, r2 *R2, key string) []byte {
+	t.Helper()
+	obj, err := r2.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Body.Close()
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestR2UploadSplitsParts(t *testing.T) {
+	ctx := context.Background()
+	r2, stub := newTestR2Stub(t)
+	data := testObject(2*minPartSize + 1000)
+
+	var uploadId string
+	info, err := r2.Upload(ctx, "big.bin", bytes.NewReader(data),
+		WithPartSize(minPartSize), WithConcurrency(2), WithContentType("application/octet-stream"),
+		OnUploadId(func(id string) { uploadId = id }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadId == "" || stub.parts != 3 {
+		t.Errorf("uploaded %d parts with upload id %q, want 3 parts of a multipart upload", stub.parts, uploadId)
+	}
+	if info.Size != int64(len(data)) || info.ETag == "" {
+		t.Errorf("upload returned %+v", info)
+	}
+	if !bytes.Equal(getObject(t, r2, "big.bin"), data) {
+		t.Error("the uploaded object differs from the body")
+	}
+
+	// a body smaller than one part is put in a single request
+	uploadId = ""
+	if _, err := r2.Upload(ctx, "small.bin", bytes.NewReader(data[:100]), OnUploadId(func(id string) { uploadId = id })); err != nil {
+		t.Fatal(err)
+	}
+	if uploadId != "" || stub.parts != 3 {
+		t.Errorf("a small body started the multipart upload %q", uploadId)
+	}
+	if !bytes.Equal(getObject(t, r2, "small.bin"), data[:100]) {
+		t.Error("the small object differs from the body")
+	}
+
+	if _, err := r2.Upload(ctx, "x", bytes.NewReader(data), WithPartSize(minPartSize-1)); !errorx.IsOfType(err, ErrValidation) {
+		t.Errorf("upload with a too small part size = %v, want ErrValidation", err)
+	}
+}
+
+func TestR2UploadResumesAfterFailedPart(t *testing.T) {
+	ctx := context.Background()
+	r2, stub := newTestR2Stub(t)
+	data := testObject(2*minPartSize + 1000)
+
+	// with one part at a time part 2 fails before part 3 is read
+	stub.failPart = 2
+	var uploadId string
+	_, err := r2.Upload(ctx, "big.bin", bytes.NewReader(data),
+		WithPartSize(minPartSize), WithConcurrency(1), OnUploadId(func(id string) { uploadId = id }))
+	if !errorx.IsOfType(err, ErrInternal) {
+		t.Fatalf("upload with a failing part = %v, want ErrInternal", err)
+	}
+	if stub.parts != 1 {
+		t.Errorf("uploaded %d parts after the failed one, want only part 1", stub.parts)
+	}
+	if _, err := r2.Head(ctx, "big.bin"); !errorx.IsOfType(err, ErrNotFound) {
+		t.Errorf("head of the failed upload = %v, want ErrNotFound", err)
+	}
+
+	stub.failPart = 0
+	if _, err := r2.Upload(ctx, "big.bin", bytes.NewReader(data), WithPartSize(minPartSize), ResumeUpload(uploadId)); err != nil {
+		t.Fatal(err)
+	}
+	if stub.parts != 3 {
+		t.Errorf("resuming uploaded %d parts, want the 2 missing ones", stub.parts-1)
+	}
+	if !bytes.Equal(getObject(t, r2, "big.bin"), data) {
+		t.Error("the resumed object differs from the body")
+	}
+}
+
+func TestR2AbortUploads(t *testing.T) {
+	ctx := context.Background()
+	r2, stub := newTestR2Stub(t)
+	data := testObject(minPartSize + 1000)
+	stub.failPart = 2
+
+	var ids []string
+	for _, key := range []string{"a.bin", "b.bin"} {
+		if _, err := r2.Upload(ctx, key, bytes.NewReader(data), WithPartSize(minPartSize),
+			OnUploadId(func(id string) { ids = append(ids, id) })); err == nil {
+			t.Fatalf("upload of %s succeeded with a failing part", key)
+		}
+	}
+	if len(stub.uploads) != 2 {
+		t.Fatalf("%d unfinished uploads, want 2", len(stub.uploads))
+	}
+
+	if err := r2.Abort(ctx, "a.bin", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r2.Upload(ctx, "a.bin", bytes.NewReader(data), WithPartSize(minPartSize), ResumeUpload(ids[0])); !errorx.IsOfType(err, ErrNotFound) {
+		t.Errorf("resuming an aborted upload = %v, want ErrNotFound", err)
+	}
+
+	if n, err := r2.AbortOrphaned(ctx, time.Hour); err != nil || n != 0 {
+		t.Errorf("AbortOrphaned of recent uploads = %d, %v, want none", n, err)
+	}
+	if n, err := r2.AbortOrphaned(ctx, 0); err != nil || n != 1 {
+		t.Errorf("AbortOrphaned = %d, %v, want 1", n, err)
+	}
+	if len(stub.uploads) != 0 {
+		t.Errorf("%d uploads are left", len(stub.uploads))
+	}
+}
+
+func TestR2DownloadFetchesRanges(t *testing.T) {
+	ctx := context.Background()
+	r2, stub := newTestR2Stub(t)
+	data := testObject(2*minPartSize + 1000)
+	if _, err := r2.Put(ctx, "big.bin", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := r2.Download(ctx, "big.bin", f, WithPartSize(minPartSize), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.ranges != 3 || info.Size != int64(len(data)) {
+		t.Errorf("downloaded %d bytes in %d ranges, want %d bytes in 3", info.Size, stub.ranges, len(data))
+	}
+	downloaded, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, data) {
+		t.Error("the downloaded file differs from the object")
+	}
+
+	if _, err := r2.Download(ctx, "missing", f); !errorx.IsOfType(err, ErrNotFound) {
+		t.Errorf("download of a missing object = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,427 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// deleteBatchSize is the maximum number of keys S3 accepts per DeleteObjects call.
+const deleteBatchSize = 1000
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag"`
+	ContentType  string            `json:"content_type"`
+	LastModified time.Time         `json:"last_modified"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// Object is a streamed object, the caller has to close the body.
+type Object struct {
+	ObjectInfo
+	Body io.ReadCloser
+}
+
+type objectOptions struct {
+	bucket        string
+	sourceBucket  string
+	contentType   string
+	cacheControl  string
+	contentLength *int64
+	metadata      map[string]string
+	byteRange     string
+	expiry        time.Duration
+	minSize       int64
+	maxSize       int64
+	downloadName  string
+	partSize      int64
+	concurrency   int
+	uploadId      string
+	onUploadId    func(uploadId string)
+}
+
+type ObjectOption func(*objectOptions)
+
+// InBucket uses bucket instead of the configured default bucket.
+func InBucket(bucket string) ObjectOption {
+	return func(o *objectOptions) {
+		o.bucket = bucket
+	}
+}
+
+// FromBucket sets the source bucket of Copy, defaults to the target bucket.
+func FromBucket(bucket string) ObjectOption {
+	return func(o *objectOptions) {
+		o.sourceBucket = bucket
+	}
+}
+
+func WithContentType(contentType string) ObjectOption {
+	return func(o *objectOptions) {
+		o.contentType = contentType
+	}
+}
+
+func WithCacheControl(cacheControl string) ObjectOption {
+	return func(o *objectOptions) {
+		o.cacheControl = cacheControl
+	}
+}
+
+// WithContentLength is required by Put for bodies that are not seekable.
+func WithContentLength(length int64) ObjectOption {
+	return func(o *objectOptions) {
+		o.contentLength = aws.Int64(length)
+	}
+}
+
+func WithMetadata(metadata map[string]string) ObjectOption {
+	return func(o *objectOptions) {
+		o.metadata = metadata
+	}
+}
+
+// WithRange makes Get return the bytes from start to end inclusive.
+func WithRange(start, end int64) ObjectOption {
+	return func(o *objectOptions) {
+		o.byteRange = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+}
+
+// options applies opts and resolves the bucket.
+func (r *R2) options(opts []ObjectOption) (*objectOptions, error) {
+	return applyObjectOptions(r.Bucket, opts)
+}
+
+// applyObjectOptions applies opts, falling back to the default bucket.
+func applyObjectOptions(bucket string, opts []ObjectOption) (*objectOptions, error) {
+	o := &objectOptions{bucket: bucket}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.bucket == "" {
+		return nil, ErrInternal.New("no bucket given and no default bucket configured")
+	}
+	if o.sourceBucket == "" {
+		o.sourceBucket = o.bucket
+	}
+	return o, nil
+}
+
+// Put uploads body to key.
+func (r *R2) Put(ctx context.Context, key string, body io.Reader, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "putting %s failed", key)
+	}
+
+	out, err := r.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(o.bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentType:   optionalString(o.contentType),
+		CacheControl:  optionalString(o.cacheControl),
+		ContentLength: o.contentLength,
+		Metadata:      o.metadata,
+	})
+	if err != nil {
+		return nil, r2Error(err, "putting %s failed", key)
+	}
+
+	size := aws.ToInt64(out.Size)
+	if out.Size == nil {
+		// not every store reports the size of a put
+		size = aws.ToInt64(o.contentLength)
+	}
+	return &ObjectInfo{
+		Key:         key,
+		Size:        size,
+		ETag:        aws.ToString(out.ETag),
+		ContentType: o.contentType,
+		Metadata:    o.metadata,
+	}, nil
+}
+
+// Get streams the object at key.
+func (r *R2) Get(ctx context.Context, key string, opts ...ObjectOption) (*Object, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "getting %s failed", key)
+	}
+
+	out, err := r.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+		Range:  optionalString(o.byteRange),
+	})
+	if err != nil {
+		return nil, r2Error(err, "getting %s failed", key)
+	}
+
+	return &Object{
+		ObjectInfo: ObjectInfo{
+			Key:          key,
+			Size:         aws.ToInt64(out.ContentLength),
+			ETag:         aws.ToString(out.ETag),
+			ContentType:  aws.ToString(out.ContentType),
+			LastModified: aws.ToTime(out.LastModified),
+			Metadata:     out.Metadata,
+		},
+		Body: out.Body,
+	}, nil
+}
+
+// Head returns the metadata of the object at key.
+func (r *R2) Head(ctx context.Context, key string, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "heading %s failed", key)
+	}
+
+	out, err := r.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, r2Error(err, "heading %s failed", key)
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         aws.ToString(out.ETag),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+// Delete removes the object at key, deleting a missing key is not an error.
+func (r *R2) Delete(ctx context.Context, key string, opts ...ObjectOption) error {
+	o, err := r.options(opts)
+	if err != nil {
+		return err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return r2Error(err, "deleting %s failed", key)
+	}
+
+	_, err = r.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return r2Error(err, "deleting %s failed", key)
+	}
+	return nil
+}
+
+// DeleteMany removes all keys in batches of 1000. Keys that could not be
+// deleted are reported together in the returned error.
+func (r *R2) DeleteMany(ctx context.Context, keys []string, opts ...ObjectOption) error {
+	o, err := r.options(opts)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		batch := keys[start:min(start+deleteBatchSize, len(keys))]
+		objects := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		if err := r.Wait(ctx); err != nil {
+			return r2Error(err, "deleting %d object(s) failed", len(keys))
+		}
+		out, err := r.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(o.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return r2Error(err, "deleting %d object(s) failed", len(keys))
+		}
+		for _, e := range out.Errors {
+			failed = append(failed, fmt.Sprintf("%s: %s", aws.ToString(e.Key), aws.ToString(e.Message)))
+		}
+	}
+
+	if len(failed) > 0 {
+		return ErrInternal.New("deleting %d of %d object(s) failed: %s", len(failed), len(keys), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// Copy copies srcKey to dstKey. Use FromBucket to copy across buckets.
+func (r *R2) Copy(ctx context.Context, srcKey, dstKey string, opts ...ObjectOption) (*ObjectInfo, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "copying %s to %s failed", srcKey, dstKey)
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(o.bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(o.sourceBucket + "/" + url.PathEscape(srcKey)),
+	}
+	if o.metadata != nil || o.contentType != "" {
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.Metadata = o.metadata
+		input.ContentType = optionalString(o.contentType)
+	}
+
+	out, err := r.Client.CopyObject(ctx, input)
+	if err != nil {
+		return nil, r2Error(err, "copying %s to %s failed", srcKey, dstKey)
+	}
+
+	info := &ObjectInfo{Key: dstKey, ContentType: o.contentType, Metadata: o.metadata}
+	if out.CopyObjectResult != nil {
+		info.ETag = aws.ToString(out.CopyObjectResult.ETag)
+		info.LastModified = aws.ToTime(out.CopyObjectResult.LastModified)
+	}
+	return info, nil
+}
+
+type ListOptions struct {
+	Prefix string
+	// Delimiter groups keys sharing a prefix up to the delimiter into
+	// ObjectPage.Prefixes, e.g. "/" to list a single directory level.
+	Delimiter string
+	// Cursor continues a previous listing, see ObjectPage.NextCursor.
+	Cursor string
+	// Limit is the maximum number of keys per page, at most 1000.
+	Limit int32
+}
+
+type ObjectPage struct {
+	Objects  []ObjectInfo
+	Prefixes []string
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// List returns a single page of objects.
+func (r *R2) List(ctx context.Context, list ListOptions, opts ...ObjectOption) (*ObjectPage, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, r2Error(err, "listing %s failed", list.Prefix)
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:            aws.String(o.bucket),
+		Prefix:            optionalString(list.Prefix),
+		Delimiter:         optionalString(list.Delimiter),
+		ContinuationToken: optionalString(list.Cursor),
+	}
+	if list.Limit > 0 {
+		input.MaxKeys = aws.Int32(list.Limit)
+	}
+
+	out, err := r.Client.ListObjectsV2(ctx, input)
+	if err != nil {
+		return nil, r2Error(err, "listing %s failed", list.Prefix)
+	}
+
+	page := &ObjectPage{
+		Objects:  make([]ObjectInfo, 0, len(out.Contents)),
+		Prefixes: make([]string, 0, len(out.CommonPrefixes)),
+	}
+	for _, obj := range out.Contents {
+		page.Objects = append(page.Objects, ObjectInfo{
+			Key:          aws.ToString(obj.Key),
+			Size:         aws.ToInt64(obj.Size),
+			ETag:         aws.ToString(obj.ETag),
+			LastModified: aws.ToTime(obj.LastModified),
+		})
+	}
+	for _, prefix := range out.CommonPrefixes {
+		page.Prefixes = append(page.Prefixes, aws.ToString(prefix.Prefix))
+	}
+	if aws.ToBool(out.IsTruncated) {
+		page.NextCursor = aws.ToString(out.NextContinuationToken)
+	}
+	return page, nil
+}
+
+// ListAll calls fn for every object below prefix, following all pages.
+func (r *R2) ListAll(ctx context.Context, prefix string, fn func(ObjectInfo) error, opts ...ObjectOption) error {
+	list := ListOptions{Prefix: prefix}
+	for {
+		page, err := r.List(ctx, list, opts...)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		list.Cursor = page.NextCursor
+	}
+}
+
+// r2Error maps S3 API errors onto the draken error types. Errors caused
+// by the credentials or the bucket are misconfigurations of the server and
+// map to ErrInternal, so clients never see them as their own fault.
+func r2Error(err error, format string, args ...any) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout.Wrap(err, format, args...)
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return ErrInternal.Wrap(err, format, args...)
+	}
+
+	switch apiErr.ErrorCode() {
+	case "NoSuchKey", "NotFound", "NoSuchUpload":
+		return ErrNotFound.Wrap(err, format, args...)
+	case "SlowDown", "TooManyRequests":
+		return ErrRateLimited.Wrap(err, format, args...)
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return ErrConflict.Wrap(err, format, args...)
+	case "InvalidArgument", "InvalidRange", "EntityTooLarge", "EntityTooSmall", "InvalidPart", "InvalidObjectName", "KeyTooLongError":
+		return ErrValidation.Wrap(err, format, args...)
+	}
+	return ErrInternal.Wrap(err, format, args...)
+}
+
+// optionalString returns nil for empty strings so they are omitted from requests.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
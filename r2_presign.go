@@ -0,0 +1,192 @@
+package draken
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultPresignExpiry = 15 * time.Minute
+	// maxPresignExpiry is the longest validity SigV4 allows.
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// Presigner signs requests that clients send directly to the bucket.
+type Presigner interface {
+	PresignGet(ctx context.Context, key string, opts ...ObjectOption) (*PresignedRequest, error)
+	PresignPut(ctx context.Context, key string, opts ...ObjectOption) (*PresignedRequest, error)
+	PresignPost(ctx context.Context, key string, opts ...ObjectOption) (*PresignedPost, error)
+}
+
+var _ Presigner = (*R2)(nil)
+
+// PresignedRequest is a signed url, the client has to send the given
+// headers unchanged.
+type PresignedRequest struct {
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Header    http.Header `json:"header,omitempty"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+// PresignedPost is a signed html form upload, the fields have to be sent
+// as form values before the file.
+type PresignedPost struct {
+	URL       string            `json:"url"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// WithExpiry sets how long a presigned request is valid, defaults to 15
+// minutes.
+func WithExpiry(expiry time.Duration) ObjectOption {
+	return func(o *objectOptions) {
+		o.expiry = expiry
+	}
+}
+
+// WithSizeLimit restricts the size of a PresignPost upload to the range
+// from min to max bytes. PresignPut restricts the size with
+// WithContentLength instead.
+func WithSizeLimit(min, max int64) ObjectOption {
+	return func(o *objectOptions) {
+		o.minSize = min
+		o.maxSize = max
+	}
+}
+
+// WithDownloadName makes a PresignGet url download the object as an
+// attachment with the given file name.
+func WithDownloadName(name string) ObjectOption {
+	return func(o *objectOptions) {
+		o.downloadName = name
+	}
+}
+
+// presignOptions resolves opts and validates the expiry.
+func (r *R2) presignOptions(opts []ObjectOption) (*objectOptions, error) {
+	o, err := r.options(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.expiry == 0 {
+		o.expiry = defaultPresignExpiry
+	}
+	if o.expiry < 0 || o.expiry > maxPresignExpiry {
+		return nil, ErrValidation.New("presign expiry must be between 0 and %s, got %s", maxPresignExpiry, o.expiry)
+	}
+	return o, nil
+}
+
+// PresignGet signs a download url for key.
+func (r *R2) PresignGet(ctx context.Context, key string, opts ...ObjectOption) (*PresignedRequest, error) {
+	o, err := r.presignOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket:              aws.String(o.bucket),
+		Key:                 aws.String(key),
+		Range:               optionalString(o.byteRange),
+		ResponseContentType: optionalString(o.contentType),
+	}
+	if o.downloadName != "" {
+		input.ResponseContentDisposition = aws.String(fmt.Sprintf("attachment; filename=%q", o.downloadName))
+	}
+
+	req, err := s3.NewPresignClient(r.Client).PresignGetObject(ctx, input, s3.WithPresignExpires(o.expiry))
+	if err != nil {
+		return nil, r2Error(err, "presigning get of %s failed", key)
+	}
+	return &PresignedRequest{
+		Method:    req.Method,
+		URL:       req.URL,
+		Header:    req.SignedHeader,
+		ExpiresAt: time.Now().Add(o.expiry),
+	}, nil
+}
+
+// PresignPut signs an upload url for key. Content type, length, cache
+// control and metadata are signed, so the client has to send them exactly.
+func (r *R2) PresignPut(ctx context.Context, key string, opts ...ObjectOption) (*PresignedRequest, error) {
+	o, err := r.presignOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s3.NewPresignClient(r.Client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(o.bucket),
+		Key:           aws.String(key),
+		ContentType:   optionalString(o.contentType),
+		ContentLength: o.contentLength,
+		CacheControl:  optionalString(o.cacheControl),
+		Metadata:      o.metadata,
+	}, s3.WithPresignExpires(o.expiry))
+	if err != nil {
+		return nil, r2Error(err, "presigning put of %s failed", key)
+	}
+
+	header := req.SignedHeader.Clone()
+	// the http client sets these itself
+	header.Del("Host")
+	return &PresignedRequest{
+		Method:    req.Method,
+		URL:       req.URL,
+		Header:    header,
+		ExpiresAt: time.Now().Add(o.expiry),
+	}, nil
+}
+
+// PresignPost signs a browser form upload for key. Unlike PresignPut it
+// can restrict the size to a range with WithSizeLimit. Cloudflare R2 does
+// not support form uploads, use PresignPut there.
+func (r *R2) PresignPost(ctx context.Context, key string, opts ...ObjectOption) (*PresignedPost, error) {
+	o, err := r.presignOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	var conditions []any
+	if o.contentType != "" {
+		fields["Content-Type"] = o.contentType
+		conditions = append(conditions, []any{"eq", "$Content-Type", o.contentType})
+	}
+	if o.cacheControl != "" {
+		fields["Cache-Control"] = o.cacheControl
+		conditions = append(conditions, []any{"eq", "$Cache-Control", o.cacheControl})
+	}
+	for k, v := range o.metadata {
+		fields["x-amz-meta-"+k] = v
+		conditions = append(conditions, map[string]string{"x-amz-meta-" + k: v})
+	}
+	if o.maxSize > 0 {
+		conditions = append(conditions, []any{"content-length-range", o.minSize, o.maxSize})
+	}
+
+	req, err := s3.NewPresignClient(r.Client).PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	}, func(po *s3.PresignPostOptions) {
+		po.Expires = o.expiry
+		po.Conditions = conditions
+	})
+	if err != nil {
+		return nil, r2Error(err, "presigning post of %s failed", key)
+	}
+
+	for k, v := range req.Values {
+		fields[k] = v
+	}
+	return &PresignedPost{
+		URL:       req.URL,
+		Fields:    fields,
+		ExpiresAt: time.Now().Add(o.expiry),
+	}, nil
+}
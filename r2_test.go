@@ -0,0 +1,411 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/joomcode/errorx"
+)
+
+const testBucket = "test-bucket"
+
+// s3Stub serves the path style S3 api for one bucket from a
+// MemoryObjectStore. Keys below denied/ fail with AccessDenied.
+type s3Stub struct {
+	t     *testing.T
+	store *MemoryObjectStore
+
+	mu      sync.Mutex
+	uploads map[string]*stubUpload
+	// failPart makes uploads of the part with this number fail.
+	failPart int32
+	// parts and ranges count the uploaded parts and the ranged gets.
+	parts  int
+	ranges int
+}
+
+type stubUpload struct {
+	key         string
+	contentType string
+	initiated   time.Time
+	parts       map[int32][]byte
+}
+
+func newTestR2(t *testing.T) *R2 {
+	t.Helper()
+	r2, _ := newTestR2Stub(t)
+	return r2
+}
+
+func newTestR2Stub(t *testing.T) (*R2, *s3Stub) {
+	t.Helper()
+	stub := &s3Stub{t: t, store: NewMemoryObjectStore(testBucket), uploads: map[string]*stubUpload{}}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	r2, err := NewR2(ObjectStorageConfig{
+		Provider:        ObjectStorageProviderCustom,
+		Endpoint:        srv.URL,
+		Bucket:          testBucket,
+		Checksum:        ChecksumWhenRequired,
+		AccessKeyId:     "key",
+		AccessKeySecret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r2.Stop)
+	return r2, stub
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if strings.HasPrefix(r.Host, testBucket+".") {
+		s.t.Errorf("request %s uses a virtual hosted bucket", r.URL)
+	}
+	if bucket != testBucket {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if strings.HasPrefix(key, "denied/") {
+		s.error(w, r, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	switch {
+	case q.Has("uploads") || q.Has("uploadId"):
+		s.multipart(w, r, key)
+	case r.Method == http.MethodGet && key == "":
+		s.list(w, r)
+	case r.Method == http.MethodPut:
+		opts := []ObjectOption{WithContentType(r.Header.Get("Content-Type"))}
+		if metadata := amzMetadata(r.Header); len(metadata) > 0 {
+			opts = append(opts, WithMetadata(metadata))
+		}
+		info, err := s.store.Put(ctx, key, r.Body, opts...)
+		if err != nil {
+			s.t.Errorf("put %s: %v", key, err)
+		}
+		w.Header().Set("ETag", info.ETag)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		var opts []ObjectOption
+		if rng := r.Header.Get("Range"); rng != "" {
+			s.mu.Lock()
+			s.ranges++
+			s.mu.Unlock()
+			var start, end int64
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			opts = append(opts, WithRange(start, end))
+		}
+		obj, err := s.store.Get(ctx, key, opts...)
+		if err != nil {
+			s.error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		defer obj.Body.Close()
+		h := w.Header()
+		h.Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		h.Set("Content-Type", obj.ContentType)
+		h.Set("ETag", obj.ETag)
+		h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+		for k, v := range obj.Metadata {
+			h.Set("X-Amz-Meta-"+k, v)
+		}
+		if len(opts) > 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if r.Method == http.MethodGet {
+			io.Copy(w, obj.Body)
+		}
+	case r.Method == http.MethodDelete:
+		s.store.Delete(ctx, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		s.t.Errorf("unexpected bucket request %s", r.URL)
+	}
+	limit, _ := strconv.Atoi(q.Get("max-keys"))
+	page, err := s.store.List(r.Context(), ListOptions{
+		Prefix:    q.Get("prefix"),
+		Delimiter: q.Get("delimiter"),
+		Cursor:    q.Get("continuation-token"),
+		Limit:     int32(limit),
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	type prefix struct {
+		Prefix string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name                  string
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+		CommonPrefixes        []prefix
+	}{Name: testBucket, IsTruncated: page.NextCursor != "", NextContinuationToken: page.NextCursor}
+	for _, obj := range page.Objects {
+		result.Contents = append(result.Contents, content{
+			Key:          obj.Key,
+			LastModified: obj.LastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.ETag,
+			Size:         obj.Size,
+		})
+	}
+	for _, p := range page.Prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, prefix{Prefix: p})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// multipart serves the multipart upload requests, completed uploads are
+// put into the store.
+func (s *s3Stub) multipart(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	upload, ok := s.uploads[q.Get("uploadId")]
+	if q.Has("uploadId") && !ok {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &stubUpload{key: key, contentType: r.Header.Get("Content-Type"), initiated: time.Now(), parts: map[int32][]byte{}}
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, testBucket, key, id)
+	case r.Method == http.MethodGet && q.Has("uploads"):
+		fmt.Fprint(w, `<ListMultipartUploadsResult><IsTruncated>false</IsTruncated>`)
+		for id, u := range s.uploads {
+			fmt.Fprintf(w, `<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>`, u.key, id, u.initiated.UTC().Format("2006-01-02T15:04:05.000Z"))
+		}
+		fmt.Fprint(w, `</ListMultipartUploadsResult>`)
+	case r.Method == http.MethodPut:
+		number, _ := strconv.Atoi(q.Get("partNumber"))
+		if int32(number) == s.failPart {
+			s.error(w, r, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		upload.parts[int32(number)] = data
+		s.parts++
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodGet:
+		fmt.Fprint(w, `<ListPartsResult><IsTruncated>false</IsTruncated>`)
+		for _, number := range slices.Sorted(maps.Keys(upload.parts)) {
+			sum := md5.Sum(upload.parts[number])
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%s"</ETag><Size>%d</Size></Part>`, number, hex.EncodeToString(sum[:]), len(upload.parts[number]))
+		}
+		fmt.Fprint(w, `</ListPartsResult>`)
+	case r.Method == http.MethodPost:
+		var complete struct {
+			Parts []struct{ PartNumber int32 } `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			s.t.Errorf("decoding the completed parts: %v", err)
+		}
+		var data []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != int32(i+1) {
+				s.t.Errorf("completed part %d has number %d", i+1, part.PartNumber)
+			}
+			data = append(data, upload.parts[part.PartNumber]...)
+		}
+		info, err := s.store.Put(r.Context(), key, bytes.NewReader(data), WithContentType(upload.contentType))
+		if err != nil {
+			s.t.Errorf("put %s: %v", key, err)
+		}
+		delete(s.uploads, q.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>`, key, info.ETag)
+	case r.Method == http.MethodDelete:
+		delete(s.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// error writes an S3 error, HEAD responses carry no body like on S3.
+func (s *s3Stub) error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+	}
+}
+
+func amzMetadata(h http.Header) map[string]string {
+	metadata := map[string]string{}
+	for k := range h {
+		if name, ok := strings.CutPrefix(strings.ToLower(k), "x-amz-meta-"); ok {
+			metadata[name] = h.Get(k)
+		}
+	}
+	return metadata
+}
+
+func TestR2RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	r2 := newTestR2(t)
+
+	info, err := r2.Put(ctx, "docs/a.txt", strings.NewReader("hello world"),
+		WithContentType("text/plain"), WithMetadata(map[string]string{"owner": "1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ETag == "" {
+		t.Error("put returned no etag")
+	}
+	for _, key := range []string{"docs/b.txt", "docs/sub/c.txt", "other.txt"} {
+		if _, err := r2.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	obj, err := r2.Get(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if string(body) != "hello world" || obj.ContentType != "text/plain" || obj.Metadata["owner"] != "1" || obj.ETag != info.ETag {
+		t.Errorf("get returned %q %+v", body, obj.ObjectInfo)
+	}
+
+	obj, err = r2.Get(ctx, "docs/a.txt", WithRange(6, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(obj.Body)
+	obj.Body.Close()
+	if string(body) != "world" {
+		t.Errorf("ranged get returned %q", body)
+	}
+
+	head, err := r2.Head(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != 11 || head.ContentType != "text/plain" || head.LastModified.IsZero() {
+		t.Errorf("head returned %+v", head)
+	}
+
+	page, err := r2.List(ctx, ListOptions{Prefix: "docs/", Delimiter: "/", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 1 || page.Objects[0].Key != "docs/a.txt" || page.NextCursor == "" {
+		t.Errorf("first page = %+v", page)
+	}
+	var keys []string
+	if err := r2.ListAll(ctx, "docs/", func(obj ObjectInfo) error {
+		keys = append(keys, obj.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "docs/a.txt,docs/b.txt,docs/sub/c.txt" {
+		t.Errorf("listed %v", keys)
+	}
+
+	if err := r2.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r2.Head(ctx, "docs/a.txt"); !errorx.IsOfType(err, ErrNotFound) {
+		t.Errorf("head after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestR2Errors(t *testing.T) {
+	ctx := context.Background()
+	r2 := newTestR2(t)
+
+	tests := []struct {
+		name string
+		call func() error
+		want *errorx.Type
+	}{
+		{"missing get", func() error { _, err := r2.Get(ctx, "missing"); return err }, ErrNotFound},
+		{"missing head", func() error { _, err := r2.Head(ctx, "missing"); return err }, ErrNotFound},
+		{"denied get", func() error { _, err := r2.Get(ctx, "denied/x"); return err }, ErrInternal},
+		{"denied head", func() error { _, err := r2.Head(ctx, "denied/x"); return err }, ErrInternal},
+		{"missing bucket", func() error { _, err := r2.Get(ctx, "x", InBucket("other")); return err }, ErrInternal},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); !errorx.IsOfType(err, tt.want) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestR2ErrorMapping(t *testing.T) {
+	tests := []struct {
+		code string
+		want *errorx.Type
+	}{
+		{"NoSuchKey", ErrNotFound},
+		{"NotFound", ErrNotFound},
+		{"NoSuchUpload", ErrNotFound},
+		{"NoSuchBucket", ErrInternal},
+		{"AccessDenied", ErrInternal},
+		{"Forbidden", ErrInternal},
+		{"InvalidAccessKeyId", ErrInternal},
+		{"SignatureDoesNotMatch", ErrInternal},
+		{"SlowDown", ErrRateLimited},
+		{"PreconditionFailed", ErrConflict},
+		{"InvalidRange", ErrValidation},
+	}
+	for _, tt := range tests {
+		err := r2Error(&smithy.GenericAPIError{Code: tt.code}, "failed")
+		if !errorx.IsOfType(err, tt.want) {
+			t.Errorf("%s mapped to %v, want %s", tt.code, err, tt.want)
+		}
+	}
+
+	for _, ctxErr := range []error{context.DeadlineExceeded, context.Canceled} {
+		if err := r2Error(fmt.Errorf("operation error S3: GetObject, %w", ctxErr), "failed"); !errorx.IsOfType(err, ErrTimeout) {
+			t.Errorf("%v mapped to %v, want ErrTimeout", ctxErr, err)
+		}
+	}
+	if err := r2Error(errors.New("boom"), "failed"); !errorx.IsOfType(err, ErrInternal) {
+		t.Errorf("plain error mapped to %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// ContextKeyUserId holds the id of the authenticated user, it is used by
+// KeyByUser.
+const ContextKeyUserId ContextKey = "draken-user-id"
+
+// RateLimit describes how many requests a key may make.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed per Window.
+	Limit  int
+	Window time.Duration
+	// Burst is the capacity of the token bucket, defaults to Limit. It is
+	// ignored by the sliding window.
+	Burst int
+}
+
+// RateLimitResult is the decision for a single request.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limit is fully available again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, it is only
+	// set if the request was denied.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore counts the requests of each key.
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// TokenBucketCache is implemented by caches that can take from a token
+// bucket atomically, it is required by the cache store for token buckets.
+type TokenBucketCache interface {
+	TakeToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, tokens float64, err error)
+}
+
+// RateLimitKeyFunc returns the key requests are counted by.
+type RateLimitKeyFunc func(c echo.Context) string
+
+// KeyByIp counts requests per client ip.
+func KeyByIp(c echo.Context) string {
+	return "ip:" + CloudflareCompatibleIP(c)
+}
+
+// KeyByUser counts requests per authenticated user and falls back to the
+// client ip for anonymous requests.
+func KeyByUser(c echo.Context) string {
+	if id, ok := c.Get(string(ContextKeyUserId)).(string); ok && id != "" {
+		return "user:" + id
+	}
+	return KeyByIp(c)
+}
+
+// RateLimiterConfig configures RateLimitMiddleware.
+type RateLimiterConfig struct {
+	RateLimit
+	// Name separates the counters of different limits, e.g. the route.
+	Name string
+	// Key defaults to KeyByIp.
+	Key RateLimitKeyFunc
+	// Store defaults to a new in-process store.
+	Store RateLimitStore
+}
+
+// RateLimitMiddleware rejects requests over the limit with ErrRateLimited
+// and sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, plus Retry-After on rejected requests. If the store fails the
+// request is let through.
+func RateLimitMiddleware(cfg RateLimiterConfig) echo.MiddlewareFunc {
+	if cfg.Key == nil {
+		cfg.Key = KeyByIp
+	}
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryRateLimitStore()
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = cfg.Limit
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := "ratelimit:" + cfg.Name + ":" + cfg.Key(c)
+			res, err := cfg.Store.Allow(RequestCtx(c), key, cfg.RateLimit)
+			if err != nil {
+				log.Error().Err(err).Str("key", key).Msg("Rate limit store failed, allowing the request.")
+				return next(c)
+			}
+
+			h := c.Response().Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				return ErrRateLimited.New("rate limit of %d requests per %s exceeded", cfg.Limit, cfg.Window)
+			}
+			return next(c)
+		}
+	}
+}
+
+// RateLimit limits all routes of the router.
+func (r *Router) RateLimit(cfg RateLimiterConfig) {
+	if cfg.Name == "" {
+		cfg.Name = r.Prefix
+	}
+	r.Middleware(RateLimitMiddleware(cfg))
+}
+
+// applyRateLimits adds the rate limits configured for the prefix of the router.
+func (r *Router) applyRateLimits() {
+	prefix := r.Prefix
+	if prefix == "" {
+		prefix = "/"
+	}
+	for _, limit := range r.Draken.Config.Server.RateLimits {
+		if limit.Route != prefix {
+			continue
+		}
+
+		cfg := RateLimiterConfig{
+			RateLimit: RateLimit{
+				Algorithm: limit.Algorithm,
+				Limit:     limit.Limit,
+				Window:    limit.Window,
+				Burst:     limit.Burst,
+			},
+			Name: prefix,
+			Key:  KeyByIp,
+		}
+		if limit.Key == RateLimitKeyUser {
+			cfg.Key = KeyByUser
+		}
+		if limit.Store == RateLimitStoreCache {
+			cfg.Store = NewCacheRateLimitStore(r.Draken.Cache)
+		}
+		log.Debug().Str("route", prefix).Msgf("Limiting %s to %d requests per %s.", prefix, limit.Limit, limit.Window)
+		r.RateLimit(cfg)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tokenBucketTTL is how long an untouched bucket is kept, after which it
+// would be full again anyway.
+func tokenBucketTTL(rate float64, burst int) time.Duration {
+	return time.Duration(float64(burst)/rate*float64(time.Second)) + time.Second
+}
+
+// tokenBucketResult builds the result of a token bucket holding tokens
+// after the request.
+func tokenBucketResult(allowed bool, tokens, rate float64, limit RateLimit) RateLimitResult {
+	res := RateLimitResult{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(tokens),
+		Reset:     time.Duration((float64(limit.Burst) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return res
+}
+
+// slidingWindowResult weights the count of the previous window by the part
+// of it that still overlaps the sliding window.
+func slidingWindowResult(prev, curr int64, elapsed time.Duration, limit RateLimit) RateLimitResult {
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	count := float64(prev)*weight + float64(curr)
+	res := RateLimitResult{
+		Allowed:   count <= float64(limit.Limit),
+		Limit:     limit.Limit,
+		Remaining: max(0, limit.Limit-int(math.Ceil(count))),
+		Reset:     limit.Window - elapsed,
+	}
+	if !res.Allowed {
+		res.RetryAfter = limit.Window - elapsed
+	}
+	return res
+}
+
+// MemoryRateLimitStore keeps the counters in the process, every replica
+// enforces its own limit.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type memoryWindow struct {
+	start      time.Time
+	prev, curr int64
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*memoryBucket),
+		windows:   make(map[string]*memoryWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now, limit.Window)
+
+	switch limit.Algorithm {
+	case RateLimitTokenBucket:
+		every := limit.Window / time.Duration(limit.Limit)
+		b, ok := s.buckets[key]
+		if !ok {
+			b = &memoryBucket{limiter: rate.NewLimiter(rate.Every(every), limit.Burst)}
+			s.buckets[key] = b
+		}
+		b.lastSeen = now
+
+		allowed := b.limiter.AllowN(now, 1)
+		return tokenBucketResult(allowed, b.limiter.TokensAt(now), float64(b.limiter.Limit()), limit), nil
+	case RateLimitSlidingWindow:
+		w, ok := s.windows[key]
+		if !ok {
+			w = &memoryWindow{start: now.Truncate(limit.Window)}
+			s.windows[key] = w
+		}
+		// advance to the window now falls into
+		if passed := now.Sub(w.start) / limit.Window; passed > 0 {
+			if passed == 1 {
+				w.prev = w.curr
+			} else {
+				w.prev = 0
+			}
+			w.curr = 0
+			w.start = w.start.Add(passed * limit.Window)
+		}
+
+		// denied requests are counted as well, like the cache store does
+		w.curr++
+		return slidingWindowResult(w.prev, w.curr, now.Sub(w.start), limit), nil
+	}
+	return RateLimitResult{}, ErrInternal.New("unknown rate limit algorithm %d", limit.Algorithm)
+}
+
+// sweep drops idle counters once per window so that the maps do not grow
+// with every client ever seen. The caller must hold s.mu.
+func (s *MemoryRateLimitStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.lastSeen) > 2*window {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if now.Sub(w.start) > 2*window {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// CacheRateLimitStore keeps the counters in the Cache, so replicas sharing
+// a redis cache share the limits.
+type CacheRateLimitStore struct {
+	Cache Cache
+}
+
+func NewCacheRateLimitStore(cache Cache) *CacheRateLimitStore {
+	return &CacheRateLimitStore{Cache: cache}
+}
+
+func (s *CacheRateLimitStore) Allow(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if s.Cache == nil {
+		return RateLimitResult{}, ErrInternal.New("the cache rate limit store needs an enabled cache")
+	}
+
+	switch limit.Algorithm {
+	case RateLimitTokenBucket:
+		tb, ok := s.Cache.(TokenBucketCache)
+		if !ok {
+			return RateLimitResult{}, ErrInternal.New("the cache does not support token buckets")
+		}
+		r := float64(limit.Limit) / limit.Window.Seconds()
+		allowed, tokens, err := tb.TakeToken(ctx, key, r, limit.Burst)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return tokenBucketResult(allowed, tokens, r, limit), nil
+	case RateLimitSlidingWindow:
+		now := time.Now()
+		index := now.UnixNano() / int64(limit.Window)
+		start := time.Unix(0, index*int64(limit.Window))
+
+		curr, err := s.Cache.IncrCtx(ctx, fmt.Sprintf("%s:%d", key, index), 2*limit.Window)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		var prev int64
+		value, err := s.Cache.GetCtx(ctx, fmt.Sprintf("%s:%d", key, index-1))
+		switch {
+		case err == nil:
+			prev, _ = strconv.ParseInt(strings.TrimSpace(*value), 10, 64)
+		case !errors.Is(err, redis.Nil):
+			return RateLimitResult{}, err
+		}
+		// denied requests are counted as well, clients that keep retrying
+		// stay limited
+		return slidingWindowResult(prev, curr, now.Sub(start), limit), nil
+	}
+	return RateLimitResult{}, ErrInternal.New("unknown rate limit algorithm %d", limit.Algorithm)
+}
+
+var (
+	_ RateLimitStore   = (*MemoryRateLimitStore)(nil)
+	_ RateLimitStore   = (*CacheRateLimitStore)(nil)
+	_ TokenBucketCache = (*Redis)(nil)
+	_ TokenBucketCache = (*Local)(nil)
+)
@@ -0,0 +1,110 @@
+package draken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestRateLimitStores returns the memory store and the cache store on
+// the local cache and on redis.
+func newTestRateLimitStores(t *testing.T) map[string]RateLimitStore {
+	t.Helper()
+	cache, _ := newTestRedis(t)
+	local := NewLocal()
+	t.Cleanup(local.Stop)
+
+	return map[string]RateLimitStore{
+		"memory": NewMemoryRateLimitStore(),
+		"local":  NewCacheRateLimitStore(local),
+		"redis":  NewCacheRateLimitStore(cache),
+	}
+}
+
+// newTestRateLimitedRoute returns a function requesting a route limited by
+// limit in store.
+func newTestRateLimitedRoute(store RateLimitStore, limit RateLimit) func() *httptest.ResponseRecorder {
+	d := &Draken{}
+	d.CreateRouter()
+	d.Router.Get("/limited", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, RateLimitMiddleware(RateLimiterConfig{RateLimit: limit, Name: "test", Store: store}))
+	return func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		d.Router.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited", nil))
+		return rec
+	}
+}
+
+func headerInt(t *testing.T, rec *httptest.ResponseRecorder, name string) int {
+	t.Helper()
+	v, err := strconv.Atoi(rec.Header().Get(name))
+	if err != nil {
+		t.Fatalf("header %s = %q, want a number", name, rec.Header().Get(name))
+	}
+	return v
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	algorithms := map[string]RateLimitAlgorithm{"token bucket": RateLimitTokenBucket, "sliding window": RateLimitSlidingWindow}
+	for algorithmName, algorithm := range algorithms {
+		for name, store := range newTestRateLimitStores(t) {
+			request := newTestRateLimitedRoute(store, RateLimit{Algorithm: algorithm, Limit: 2, Window: time.Minute})
+
+			for i := range 2 {
+				rec := request()
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s/%s: request %d = %d, want %d", algorithmName, name, i, rec.Code, http.StatusOK)
+				}
+				if got := headerInt(t, rec, "RateLimit-Limit"); got != 2 {
+					t.Errorf("%s/%s: RateLimit-Limit = %d, want 2", algorithmName, name, got)
+				}
+				if got := headerInt(t, rec, "RateLimit-Remaining"); got != 1-i {
+					t.Errorf("%s/%s: RateLimit-Remaining = %d, want %d", algorithmName, name, got, 1-i)
+				}
+				if reset := headerInt(t, rec, "RateLimit-Reset"); reset <= 0 || reset > 60 {
+					t.Errorf("%s/%s: RateLimit-Reset = %d, want 1 to 60", algorithmName, name, reset)
+				}
+				if rec.Header().Get("Retry-After") != "" {
+					t.Errorf("%s/%s: allowed request has a Retry-After header", algorithmName, name)
+				}
+			}
+
+			rec := request()
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("%s/%s: request over the limit = %d, want %d", algorithmName, name, rec.Code, http.StatusTooManyRequests)
+			}
+			if got := headerInt(t, rec, "RateLimit-Remaining"); got != 0 {
+				t.Errorf("%s/%s: RateLimit-Remaining = %d, want 0", algorithmName, name, got)
+			}
+			// a token comes back after 30s, the window ends within 60s
+			if retry := headerInt(t, rec, "Retry-After"); retry <= 0 || retry > 60 {
+				t.Errorf("%s/%s: Retry-After = %d, want 1 to 60", algorithmName, name, retry)
+			}
+		}
+	}
+}
+
+func TestRateLimitStoresCountDeniedRequests(t *testing.T) {
+	const window = 400 * time.Millisecond
+	for name, store := range newTestRateLimitStores(t) {
+		request := newTestRateLimitedRoute(store, RateLimit{Algorithm: RateLimitSlidingWindow, Limit: 4, Window: window})
+
+		// start at the beginning of a window, all stores align them the same
+		time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+		for range 8 {
+			request()
+		}
+		// 40% into the next window the previous one weighs 0.6, the 8
+		// requests of it count as 4.8 and the next one exceeds the limit. Counting
+		// only the 4 allowed ones would let the request through.
+		time.Sleep(time.Until(time.Now().Truncate(window).Add(window + 4*window/10)))
+		if rec := request(); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: request after a denied burst = %d, want %d", name, rec.Code, http.StatusTooManyRequests)
+		}
+	}
+}
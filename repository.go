@@ -0,0 +1,481 @@
+package draken
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+	"github.com/uptrace/bun/schema"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+type FilterOp uint8
+
+const (
+	FilterEq FilterOp = iota
+	FilterNe
+	FilterLt
+	FilterLte
+	FilterGt
+	FilterGte
+	// FilterIn expects a slice value.
+	FilterIn
+	// FilterLike matches the value as a LIKE pattern.
+	FilterLike
+	// FilterNull matches NULL if the value is true and NOT NULL otherwise.
+	FilterNull
+	FilterUnknown FilterOp = 255
+)
+
+func (op FilterOp) sql() string {
+	switch op {
+	case FilterEq:
+		return "="
+	case FilterNe:
+		return "<>"
+	case FilterLt:
+		return "<"
+	case FilterLte:
+		return "<="
+	case FilterGt:
+		return ">"
+	case FilterGte:
+		return ">="
+	case FilterIn:
+		return "IN"
+	case FilterLike:
+		return "LIKE"
+	}
+	return ""
+}
+
+// Filter restricts a list to the rows whose column matches the value.
+type Filter struct {
+	Column string
+	Op     FilterOp
+	Value  any
+}
+
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// ListQuery selects a page of rows. The rows are ordered by Sort and then
+// by the primary key, so that the cursor of a page is unique. NULL sorts
+// after all values, last when ascending and first when descending.
+type ListQuery struct {
+	Filters []Filter
+	Sort    []Sort
+	// Limit defaults to 20 and is capped at 100.
+	Limit int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+	// WithDeleted includes soft deleted rows.
+	WithDeleted bool
+}
+
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// NextCursor is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// RepositoryConfig configures a Repository.
+type RepositoryConfig struct {
+	// VersionColumn is an integer column used for optimistic locking if the
+	// model has it, defaults to version.
+	VersionColumn string
+	// Filterable and Sortable are the columns list queries may filter and
+	// sort by. None are filterable and only the primary key is sortable if
+	// they are empty.
+	Filterable []string
+	Sortable   []string
+}
+
+// Repository implements the common queries of the bun model T with a
+// single primary key. Models with a bun soft_delete column are soft
+// deleted, models with a version column are locked optimistically.
+//
+// Queries join the transaction of the context. Reads outside of a
+// transaction go to a read replica unless the context forces the primary.
+type Repository[T any] struct {
+	Storage Storage
+	Config  RepositoryConfig
+	table   *schema.Table
+	pk      *schema.Field
+	version *schema.Field
+}
+
+// NewRepository panics with ErrInvalidConfig if T is not a bun model with
+// a single primary key.
+func NewRepository[T any](storage Storage, cfg RepositoryConfig) *Repository[T] {
+	if cfg.VersionColumn == "" {
+		cfg.VersionColumn = "version"
+	}
+
+	table := storage.Bun().Table(reflect.TypeFor[T]())
+	if len(table.PKs) != 1 {
+		panic(ErrInvalidConfig.New("repository model %s needs exactly one primary key, has %d", table.TypeName, len(table.PKs)))
+	}
+	r := &Repository[T]{Storage: storage, Config: cfg, table: table, pk: table.PKs[0]}
+	if f, ok := table.FieldMap[cfg.VersionColumn]; ok {
+		switch f.IndirectType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			r.version = f
+		default:
+			panic(ErrInvalidConfig.New("version column %s of %s must be an integer", cfg.VersionColumn, table.TypeName))
+		}
+	}
+	return r
+}
+
+// reader returns the transaction of ctx, the primary if ctx forces it and
+// a read replica otherwise.
+func (r *Repository[T]) reader(ctx context.Context) bun.IDB {
+	if _, ok := TxFrom(ctx); ok {
+		return r.Storage.IDB(ctx)
+	}
+	return r.Storage.ReadBunCtx(ctx)
+}
+
+func (r *Repository[T]) Get(ctx context.Context, id any) (*T, error) {
+	entity := new(T)
+	err := r.reader(ctx).NewSelect().Model(entity).
+		Where("?TableAlias.? = ?", bun.Ident(r.pk.Name), id).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound.New("%s %v does not exist", r.table.ModelName, id)
+	}
+	if err != nil {
+		return nil, ErrInternal.Wrap(err, "reading %s %v failed", r.table.ModelName, id)
+	}
+	return entity, nil
+}
+
+func (r *Repository[T]) List(ctx context.Context, q ListQuery) (*Page[T], error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	limit = min(limit, maxListLimit)
+
+	order := make([]orderColumn, 0, len(q.Sort)+1)
+	for _, s := range q.Sort {
+		// the primary key is always sortable, it is appended below
+		if s.Column == r.pk.Name {
+			continue
+		}
+		f, err := r.column(s.Column, r.Config.Sortable)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, orderColumn{field: f, desc: s.Desc})
+	}
+	// the primary key breaks ties, it follows the direction of the last column
+	pkDesc := len(order) > 0 && order[len(order)-1].desc
+	if len(q.Sort) > 0 && q.Sort[len(q.Sort)-1].Column == r.pk.Name {
+		pkDesc = q.Sort[len(q.Sort)-1].Desc
+	}
+	order = append(order, orderColumn{field: r.pk, desc: pkDesc})
+
+	var items []T
+	query := r.reader(ctx).NewSelect().Model(&items).Limit(limit + 1)
+	if q.WithDeleted {
+		query = query.WhereAllWithDeleted()
+	}
+	for _, filter := range q.Filters {
+		if err := r.applyFilter(query, filter); err != nil {
+			return nil, err
+		}
+	}
+	if q.Cursor != "" {
+		values, err := r.decodeCursor(q.Cursor, order)
+		if err != nil {
+			return nil, err
+		}
+		query = query.WhereGroup(" AND ", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return keysetCondition(sq, order, values)
+		})
+	}
+	for _, o := range order {
+		// the dialects differ in where they sort NULL, the keyset condition
+		// relies on it being the greatest value
+		if o.desc {
+			query = query.OrderExpr("?TableAlias.? DESC NULLS FIRST", bun.Ident(o.field.Name))
+		} else {
+			query = query.OrderExpr("?TableAlias.? ASC NULLS LAST", bun.Ident(o.field.Name))
+		}
+	}
+
+	if err := query.Scan(ctx); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInternal.Wrap(err, "listing %s failed", r.table.ModelName)
+	}
+
+	page := &Page[T]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		cursor, err := r.encodeCursor(&page.Items[limit-1], order)
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = cursor
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	return page, nil
+}
+
+// Create inserts the entity, the version of a versioned entity starts at 1.
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	if r.version != nil {
+		if v := r.version.Value(reflect.ValueOf(entity).Elem()); v.Int() == 0 {
+			v.SetInt(1)
+		}
+	}
+	if _, err := r.Storage.IDB(ctx).NewInsert().Model(entity).Exec(ctx); err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict.Wrap(err, "%s already exists", r.table.ModelName)
+		}
+		return ErrInternal.Wrap(err, "creating %s failed", r.table.ModelName)
+	}
+	return nil
+}
+
+// Update writes all columns of the entity. A versioned entity is only
+// written if its version is still the stored one, otherwise ErrConflict is
+// returned. On success the version of the entity is incremented.
+func (r *Repository[T]) Update(ctx context.Context, entity *T) error {
+	db := r.Storage.IDB(ctx)
+	query := db.NewUpdate().Model(entity).WherePK()
+
+	var version reflect.Value
+	var current int64
+	if r.version != nil {
+		version = r.version.Value(reflect.ValueOf(entity).Elem())
+		current = version.Int()
+		version.SetInt(current + 1)
+		query = query.Where("?TableAlias.? = ?", bun.Ident(r.version.Name), current)
+	}
+
+	res, err := query.Exec(ctx)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			err = r.missingOrConflict(ctx, db, entity, current)
+		}
+	} else if isUniqueViolation(err) {
+		err = ErrConflict.Wrap(err, "%s conflicts with an existing row", r.table.ModelName)
+	} else {
+		err = ErrInternal.Wrap(err, "updating %s failed", r.table.ModelName)
+	}
+	if err != nil && r.version != nil {
+		version.SetInt(current)
+	}
+	return err
+}
+
+// missingOrConflict tells a deleted row from one changed by someone else.
+func (r *Repository[T]) missingOrConflict(ctx context.Context, db bun.IDB, entity *T, version int64) error {
+	id := r.pk.Value(reflect.ValueOf(entity).Elem()).Interface()
+	exists, err := db.NewSelect().Model((*T)(nil)).
+		Where("?TableAlias.? = ?", bun.Ident(r.pk.Name), id).
+		Exists(ctx)
+	if err != nil {
+		return ErrInternal.Wrap(err, "reading %s %v failed", r.table.ModelName, id)
+	}
+	if !exists {
+		return ErrNotFound.New("%s %v does not exist", r.table.ModelName, id)
+	}
+	return ErrConflict.New("%s %v was modified since version %d", r.table.ModelName, id, version)
+}
+
+// Delete soft deletes the row if the model has a soft_delete column and
+// removes it otherwise.
+func (r *Repository[T]) Delete(ctx context.Context, id any) error {
+	return r.delete(ctx, id, false)
+}
+
+// ForceDelete removes the row, also if it is soft deleted.
+func (r *Repository[T]) ForceDelete(ctx context.Context, id any) error {
+	return r.delete(ctx, id, true)
+}
+
+func (r *Repository[T]) delete(ctx context.Context, id any, force bool) error {
+	query := r.Storage.IDB(ctx).NewDelete().Model((*T)(nil)).
+		Where("?TableAlias.? = ?", bun.Ident(r.pk.Name), id)
+	if force {
+		query = query.ForceDelete()
+	}
+	res, err := query.Exec(ctx)
+	if err != nil {
+		return ErrInternal.Wrap(err, "deleting %s %v failed", r.table.ModelName, id)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound.New("%s %v does not exist", r.table.ModelName, id)
+	}
+	return nil
+}
+
+// column returns the field of a list query column, which must be one of
+// the allowed columns.
+func (r *Repository[T]) column(name string, allowed []string) (*schema.Field, error) {
+	f, ok := r.table.FieldMap[name]
+	if !ok || !slices.Contains(allowed, name) {
+		return nil, ErrValidation.New("%s cannot be filtered or sorted by %s", r.table.ModelName, name)
+	}
+	return f, nil
+}
+
+func (r *Repository[T]) applyFilter(query *bun.SelectQuery, filter Filter) error {
+	f, err := r.column(filter.Column, r.Config.Filterable)
+	if err != nil {
+		return err
+	}
+	col := bun.Ident(f.Name)
+
+	switch filter.Op {
+	case FilterNull:
+		if null, _ := filter.Value.(bool); null {
+			query.Where("?TableAlias.? IS NULL", col)
+		} else {
+			query.Where("?TableAlias.? IS NOT NULL", col)
+		}
+	case FilterIn:
+		v := reflect.ValueOf(filter.Value)
+		if v.Kind() != reflect.Slice {
+			return ErrValidation.New("the in filter of %s needs a list", filter.Column)
+		}
+		if v.Len() == 0 {
+			query.Where("1 = 0")
+		} else {
+			query.Where("?TableAlias.? IN (?)", col, bun.In(filter.Value))
+		}
+	case FilterEq, FilterNe, FilterLt, FilterLte, FilterGt, FilterGte, FilterLike:
+		query.Where("?TableAlias.? "+filter.Op.sql()+" ?", col, filter.Value)
+	default:
+		return ErrValidation.New("unknown filter operator %d", filter.Op)
+	}
+	return nil
+}
+
+type orderColumn struct {
+	field *schema.Field
+	desc  bool
+}
+
+// keysetCondition selects the rows after the cursor values, for the
+// columns a, b it is a > va OR (a = va AND b > vb) with < for descending
+// columns. A nil value is NULL, which sorts after all values.
+func keysetCondition(q *bun.SelectQuery, order []orderColumn, values []any) *bun.SelectQuery {
+	for i := range order {
+		// nothing sorts after NULL when ascending
+		if values[i] == nil && !order[i].desc {
+			continue
+		}
+		q = q.WhereGroup(" OR ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			for j := range i {
+				if values[j] == nil {
+					q = q.Where("?TableAlias.? IS NULL", bun.Ident(order[j].field.Name))
+				} else {
+					q = q.Where("?TableAlias.? = ?", bun.Ident(order[j].field.Name), values[j])
+				}
+			}
+			col := bun.Ident(order[i].field.Name)
+			switch {
+			case values[i] == nil:
+				return q.Where("?TableAlias.? IS NOT NULL", col)
+			case order[i].desc:
+				return q.Where("?TableAlias.? < ?", col, values[i])
+			default:
+				return q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+					return q.Where("?TableAlias.? > ?", col, values[i]).WhereOr("?TableAlias.? IS NULL", col)
+				})
+			}
+		})
+	}
+	return q
+}
+
+// cursor is the base64 encoded json array of the order column values of
+// the last row of a page, null for the values stored as NULL.
+func (r *Repository[T]) encodeCursor(last *T, order []orderColumn) (string, error) {
+	strct := reflect.ValueOf(last).Elem()
+	values := make([]any, len(order))
+	for i, o := range order {
+		if v := o.field.Value(strct); !isNullValue(o.field, v) {
+			values[i] = v.Interface()
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return "", ErrInternal.Wrap(err, "encoding the cursor failed")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor decodes the cursor values into the types of the columns, so
+// that they are compared the way the dialect stores them.
+func (r *Repository[T]) decodeCursor(cursor string, order []orderColumn) ([]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrValidation.New("invalid cursor")
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, ErrValidation.New("invalid cursor")
+	}
+	if len(parts) != len(order) {
+		return nil, ErrValidation.New("invalid cursor, it belongs to a different sort order")
+	}
+
+	values := make([]any, len(order))
+	for i, o := range order {
+		if string(parts[i]) == "null" {
+			continue
+		}
+		v := reflect.New(o.field.StructField.Type)
+		if err := json.Unmarshal(parts[i], v.Interface()); err != nil {
+			return nil, ErrValidation.New("invalid cursor value for %s", o.field.Name)
+		}
+		values[i] = v.Elem().Interface()
+	}
+	return values, nil
+}
+
+// isNullValue reports whether bun stores the field value v as NULL.
+func isNullValue(f *schema.Field, v reflect.Value) bool {
+	if (f.IsPtr && v.IsNil()) || (f.NullZero && f.IsZero(v)) {
+		return true
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		return err == nil && value == nil
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Field('C') == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,124 @@
+package draken
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	"github.com/uptrace/bun"
+)
+
+type testItem struct {
+	bun.BaseModel `bun:"table:items"`
+
+	ID   int64 `bun:",pk,autoincrement"`
+	Name string
+	Rank *int64
+}
+
+func newTestItems(t *testing.T) (*Repository[testItem], []testItem) {
+	t.Helper()
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	if _, err := storage.Client.NewCreateTable().Model((*testItem)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[testItem](storage, RepositoryConfig{
+		Filterable: []string{"name"},
+		Sortable:   []string{"rank", "name"},
+	})
+
+	rank := func(v int64) *int64 { return &v }
+	items := []testItem{
+		{Name: "a", Rank: rank(2)},
+		{Name: "b"},
+		{Name: "c", Rank: rank(1)},
+		{Name: "d", Rank: rank(2)},
+		{Name: "e"},
+		{Name: "f", Rank: rank(1)},
+		{Name: "g"},
+		{Name: "h", Rank: rank(3)},
+	}
+	for i := range items {
+		if err := repo.Create(ctx, &items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo, items
+}
+
+// compareRank orders NULL after all ranks.
+func compareRank(a, b *int64) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return cmp.Compare(*a, *b)
+}
+
+func TestListPagesAcrossNullsAndTies(t *testing.T) {
+	repo, items := newTestItems(t)
+
+	for _, desc := range []bool{false, true} {
+		want := slices.Clone(items)
+		slices.SortFunc(want, func(a, b testItem) int {
+			c := cmp.Or(compareRank(a.Rank, b.Rank), cmp.Compare(a.ID, b.ID))
+			if desc {
+				return -c
+			}
+			return c
+		})
+
+		var got []testItem
+		q := ListQuery{Sort: []Sort{{Column: "rank", Desc: desc}}, Limit: 2}
+		for range len(items) {
+			page, err := repo.List(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, page.Items...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+
+		if !slices.EqualFunc(got, want, func(a, b testItem) bool { return a.ID == b.ID }) {
+			t.Errorf("desc %t: got %v, want %v", desc, names(got), names(want))
+		}
+	}
+}
+
+func names(items []testItem) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = item.Name
+	}
+	return out
+}
+
+func TestListColumnsAreOptIn(t *testing.T) {
+	ctx := context.Background()
+	repo, items := newTestItems(t)
+	repo.Config = RepositoryConfig{}
+
+	if _, err := repo.List(ctx, ListQuery{Filters: []Filter{{Column: "name", Op: FilterEq, Value: "a"}}}); !errorx.IsOfType(err, ErrValidation) {
+		t.Errorf("filtering by a column that is not filterable = %v, want a validation error", err)
+	}
+	if _, err := repo.List(ctx, ListQuery{Sort: []Sort{{Column: "rank"}}}); !errorx.IsOfType(err, ErrValidation) {
+		t.Errorf("sorting by a column that is not sortable = %v, want a validation error", err)
+	}
+	page, err := repo.List(ctx, ListQuery{Sort: []Sort{{Column: "id", Desc: true}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != len(items) || page.Items[0].ID != items[len(items)-1].ID {
+		t.Errorf("sorting by the primary key = %v", names(page.Items))
+	}
+}
@@ -1,6 +1,12 @@
 package draken
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
 
 type Request struct {
 	*http.Request
@@ -22,3 +28,29 @@ func (r *Request) CtxGetString(key ContextKey) string {
 func (r *Request) RequestId() string {
 	return r.CtxGetString(ContextKeyRequestId)
 }
+
+// RequestCtx returns the context of the request. It is cancelled when the
+// client goes away or the timeout of the route elapses, pass it to all
+// storage, cache and object storage calls made while handling the request.
+func RequestCtx(c echo.Context) context.Context {
+	return c.Request().Context()
+}
+
+// RequestBun returns the client of the storage and the context of the
+// request, which is cancelled when the client goes away or the route times
+// out.
+func RequestBun(c echo.Context, s Storage) (*bun.DB, context.Context) {
+	return s.Bun(), RequestCtx(c)
+}
+
+// RequestReadBun returns the read client of the storage and the context of
+// the request. Reads go to the primary after ForcePrimary.
+func RequestReadBun(c echo.Context, s Storage) (*bun.DB, context.Context) {
+	ctx := RequestCtx(c)
+	return s.ReadBunCtx(ctx), ctx
+}
+
+// Principal returns the authenticated caller, nil if the request is anonymous.
+func (r *Request) Principal() *Principal {
+	return PrincipalFromCtx(r.Context())
+}
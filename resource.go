@@ -0,0 +1,547 @@
+package draken
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun/schema"
+)
+
+type ResourceOp uint8
+
+const (
+	ResourceList ResourceOp = iota
+	ResourceGet
+	ResourceCreate
+	ResourceUpdate
+	ResourceDelete
+	ResourceUnknown ResourceOp = 255
+)
+
+func (op ResourceOp) String() string {
+	switch op {
+	case ResourceList:
+		return "list"
+	case ResourceGet:
+		return "get"
+	case ResourceCreate:
+		return "create"
+	case ResourceUpdate:
+		return "update"
+	case ResourceDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+var filterOps = map[string]FilterOp{
+	"eq":   FilterEq,
+	"ne":   FilterNe,
+	"lt":   FilterLt,
+	"lte":  FilterLte,
+	"gt":   FilterGt,
+	"gte":  FilterGte,
+	"in":   FilterIn,
+	"like": FilterLike,
+	"null": FilterNull,
+}
+
+// Envelope is the body of all resource responses.
+type Envelope struct {
+	Data any `json:"data"`
+	Meta any `json:"meta,omitempty"`
+}
+
+// ListMeta is the meta of list responses.
+type ListMeta struct {
+	Count int `json:"count"`
+	Limit int `json:"limit"`
+	// NextCursor is passed as the cursor query parameter to get the next
+	// page, it is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ResourceConfig configures the endpoints registered by Router.Resource.
+// The hooks receive the entity as a pointer to the model of the repository.
+type ResourceConfig struct {
+	// Operations are the registered endpoints, defaults to all of them.
+	Operations []ResourceOp
+	// Authorize is called before every operation. The entity is nil for
+	// lists, the bound input for creates and the stored entity otherwise.
+	// Updates call it again with the updated entity, so that a client
+	// cannot move an entity out of its reach, e.g. to another owner.
+	// Returning an error rejects the request.
+	Authorize func(c echo.Context, op ResourceOp, entity any) error
+	// ReadOnly are the json fields clients cannot set, e.g. created_at or
+	// owner_id. Creates clear them, the hooks can fill them in, and updates
+	// keep their stored values. The primary key and the soft delete column
+	// are always read only, rows are only deleted through the delete
+	// endpoint.
+	ReadOnly []string
+	// ListFilters are added to every list, e.g. to restrict it to the rows
+	// of the user.
+	ListFilters func(c echo.Context) ([]Filter, error)
+	// Validate checks created and updated entities, after the validator of
+	// echo if one is set.
+	Validate func(c echo.Context, op ResourceOp, entity any) error
+	// Project converts entities before they are rendered, e.g. to drop
+	// fields. Defaults to rendering the entity.
+	Project func(c echo.Context, op ResourceOp, entity any) any
+	// Middlewares are added to every endpoint.
+	Middlewares []echo.MiddlewareFunc
+}
+
+// ResourceRepository is implemented by Repository.
+type ResourceRepository interface {
+	newEntity() any
+	getEntity(ctx context.Context, id any) (any, error)
+	listEntities(ctx context.Context, q ListQuery) ([]any, string, error)
+	createEntity(ctx context.Context, entity any) error
+	updateEntity(ctx context.Context, entity any) error
+	deleteEntity(ctx context.Context, id any) error
+	parseId(raw string) (any, error)
+	// protect saves the primary key, the soft delete column and fields of
+	// the entity and returns a function restoring them. It clears the
+	// version, so that only a body carrying it passes updateEntity.
+	protect(entity any, fields []*schema.Field) (restore func())
+	idOf(entity any) any
+	// resetGenerated clears the columns the client may not set on create
+	// and fields.
+	resetGenerated(entity any, fields []*schema.Field)
+	// queryColumn returns the column of a json field name.
+	queryColumn(name string) (*schema.Field, bool)
+}
+
+// Resource registers the list, get, create, update and delete endpoints of
+// the repository on a subrouter at route and returns the subrouter:
+//
+//	GET    route              list, see below
+//	GET    route/:id          get
+//	POST   route              create
+//	PUT    route/:id          update
+//	PATCH  route/:id          update
+//	DELETE route/:id          delete
+//
+// Lists are filtered by query parameters named after the json fields, with
+// an optional operator, e.g. status=open, total[gte]=10, id[in]=1,2 or
+// deleted_at[null]=true. sort=-total,created_at sorts by total descending
+// and created_at, limit sets the page size and cursor selects the page.
+// Only the Filterable and Sortable columns of the repository are accepted.
+//
+// PUT and PATCH both apply the body to the stored entity, the primary key,
+// the soft delete column and the ReadOnly fields keep their stored values.
+// Versioned models are only updated if the body carries the current
+// version.
+func (r *Router) Resource(route string, repo ResourceRepository, cfg ResourceConfig) *Router {
+	if len(cfg.Operations) == 0 {
+		cfg.Operations = []ResourceOp{ResourceList, ResourceGet, ResourceCreate, ResourceUpdate, ResourceDelete}
+	}
+	if cfg.Project == nil {
+		cfg.Project = func(_ echo.Context, _ ResourceOp, entity any) any { return entity }
+	}
+	res := &resourceHandlers{repo: repo, cfg: cfg}
+	for _, name := range cfg.ReadOnly {
+		f, ok := repo.queryColumn(name)
+		if !ok {
+			panic(ErrInvalidConfig.New("read only field %s of %s is not a column", name, route))
+		}
+		res.readOnly = append(res.readOnly, f)
+	}
+
+	sr := r.CreateSubrouter(route)
+	for _, op := range cfg.Operations {
+		switch op {
+		case ResourceList:
+			sr.Get("", res.list, cfg.Middlewares...)
+		case ResourceGet:
+			sr.Get("/:id", res.get, cfg.Middlewares...)
+		case ResourceCreate:
+			sr.Post("", res.create, cfg.Middlewares...)
+		case ResourceUpdate:
+			sr.Put("/:id", res.update, cfg.Middlewares...)
+			sr.Patch("/:id", res.update, cfg.Middlewares...)
+		case ResourceDelete:
+			sr.Delete("/:id", res.delete, cfg.Middlewares...)
+		default:
+			panic(ErrInvalidConfig.New("unknown resource operation %d for %s", op, route))
+		}
+	}
+	log.Info().Str("route", sr.Prefix).Msgf("Registered resource at %s.", sr.Prefix)
+	return sr
+}
+
+type resourceHandlers struct {
+	repo     ResourceRepository
+	cfg      ResourceConfig
+	readOnly []*schema.Field
+}
+
+func (res *resourceHandlers) authorize(c echo.Context, op ResourceOp, entity any) error {
+	if res.cfg.Authorize == nil {
+		return nil
+	}
+	return res.cfg.Authorize(c, op, entity)
+}
+
+func (res *resourceHandlers) validate(c echo.Context, op ResourceOp, entity any) error {
+	if c.Echo().Validator != nil {
+		if err := c.Validate(entity); err != nil {
+			return err
+		}
+	}
+	if res.cfg.Validate != nil {
+		return res.cfg.Validate(c, op, entity)
+	}
+	return nil
+}
+
+func (res *resourceHandlers) list(c echo.Context) error {
+	q, err := res.listQuery(c)
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(c, ResourceList, nil); err != nil {
+		return err
+	}
+	if res.cfg.ListFilters != nil {
+		filters, err := res.cfg.ListFilters(c)
+		if err != nil {
+			return err
+		}
+		q.Filters = append(q.Filters, filters...)
+	}
+
+	items, next, err := res.repo.listEntities(RequestCtx(c), q)
+	if err != nil {
+		return err
+	}
+	data := make([]any, len(items))
+	for i, item := range items {
+		data[i] = res.cfg.Project(c, ResourceList, item)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	return c.JSON(http.StatusOK, Envelope{
+		Data: data,
+		Meta: ListMeta{Count: len(data), Limit: min(limit, maxListLimit), NextCursor: next},
+	})
+}
+
+func (res *resourceHandlers) get(c echo.Context) error {
+	entity, err := res.load(c, RequestCtx(c))
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(c, ResourceGet, entity); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, Envelope{Data: res.cfg.Project(c, ResourceGet, entity)})
+}
+
+func (res *resourceHandlers) create(c echo.Context) error {
+	entity := res.repo.newEntity()
+	if err := c.Bind(entity); err != nil {
+		return err
+	}
+	res.repo.resetGenerated(entity, res.readOnly)
+	if err := res.authorize(c, ResourceCreate, entity); err != nil {
+		return err
+	}
+	if err := res.validate(c, ResourceCreate, entity); err != nil {
+		return err
+	}
+
+	if err := res.repo.createEntity(RequestCtx(c), entity); err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("%s/%v", strings.TrimSuffix(c.Request().URL.Path, "/"), res.repo.idOf(entity)))
+	return c.JSON(http.StatusCreated, Envelope{Data: res.cfg.Project(c, ResourceCreate, entity)})
+}
+
+func (res *resourceHandlers) update(c echo.Context) error {
+	// the stored entity is read from the primary, a lagging replica would
+	// report a stale version
+	ctx := WithPrimary(RequestCtx(c))
+	entity, err := res.load(c, ctx)
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(c, ResourceUpdate, entity); err != nil {
+		return err
+	}
+
+	// the version of the stored entity is cleared, an update has to carry
+	// the version the client read
+	restore := res.repo.protect(entity, res.readOnly)
+	if err := c.Bind(entity); err != nil {
+		return err
+	}
+	restore()
+	if err := res.authorize(c, ResourceUpdate, entity); err != nil {
+		return err
+	}
+	if err := res.validate(c, ResourceUpdate, entity); err != nil {
+		return err
+	}
+
+	if err := res.repo.updateEntity(ctx, entity); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, Envelope{Data: res.cfg.Project(c, ResourceUpdate, entity)})
+}
+
+func (res *resourceHandlers) delete(c echo.Context) error {
+	ctx := WithPrimary(RequestCtx(c))
+	entity, err := res.load(c, ctx)
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(c, ResourceDelete, entity); err != nil {
+		return err
+	}
+
+	if err := res.repo.deleteEntity(ctx, res.repo.idOf(entity)); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// load returns the entity of the id path parameter.
+func (res *resourceHandlers) load(c echo.Context, ctx context.Context) (any, error) {
+	id, err := res.repo.parseId(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	return res.repo.getEntity(ctx, id)
+}
+
+// listQuery parses the filter, sort, limit and cursor query parameters.
+func (res *resourceHandlers) listQuery(c echo.Context) (ListQuery, error) {
+	var q ListQuery
+	fields := map[string]string{}
+
+	for key, values := range c.QueryParams() {
+		value := values[len(values)-1]
+		switch key {
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				fields["limit"] = "must be a positive number"
+			}
+			q.Limit = limit
+			continue
+		case "cursor":
+			q.Cursor = value
+			continue
+		case "sort":
+			for _, name := range strings.Split(value, ",") {
+				desc := strings.HasPrefix(name, "-")
+				name = strings.TrimPrefix(name, "-")
+				f, ok := res.repo.queryColumn(name)
+				if !ok {
+					fields["sort"] = "cannot sort by " + name
+					continue
+				}
+				q.Sort = append(q.Sort, Sort{Column: f.Name, Desc: desc})
+			}
+			continue
+		}
+
+		name, opName := key, "eq"
+		if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+			name, opName = key[:i], key[i+1:len(key)-1]
+		}
+		f, ok := res.repo.queryColumn(name)
+		if !ok {
+			fields[key] = "is not a filterable field"
+			continue
+		}
+		op, ok := filterOps[opName]
+		if !ok {
+			fields[key] = "unknown operator " + opName
+			continue
+		}
+
+		filter := Filter{Column: f.Name, Op: op}
+		var err error
+		switch op {
+		case FilterNull:
+			filter.Value, err = strconv.ParseBool(value)
+		case FilterLike:
+			filter.Value = value
+		case FilterIn:
+			parts := strings.Split(value, ",")
+			in := make([]any, len(parts))
+			for i, part := range parts {
+				if in[i], err = parseFieldValue(f, part); err != nil {
+					break
+				}
+			}
+			filter.Value = in
+		default:
+			filter.Value, err = parseFieldValue(f, value)
+		}
+		if err != nil {
+			fields[key] = "is not a valid value"
+			continue
+		}
+		q.Filters = append(q.Filters, filter)
+	}
+
+	if len(fields) > 0 {
+		return q, NewValidationError(fields)
+	}
+	// map iteration is random, sort the filters for stable queries
+	slices.SortFunc(q.Filters, func(a, b Filter) int { return strings.Compare(a.Column, b.Column) })
+	return q, nil
+}
+
+// parseFieldValue converts a query or path parameter to the type of the
+// field, so that it is compared the way the dialect stores it.
+func parseFieldValue(f *schema.Field, raw string) (any, error) {
+	v := reflect.New(f.IndirectType)
+	if u, ok := v.Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(raw)); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	}
+
+	switch f.IndirectType.Kind() {
+	case reflect.String:
+		v.Elem().SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		if err := json.Unmarshal([]byte(raw), v.Interface()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrValidation.New("%s cannot be used in parameters", f.Name)
+	}
+	return v.Elem().Interface(), nil
+}
+
+func (r *Repository[T]) newEntity() any {
+	return new(T)
+}
+
+func (r *Repository[T]) getEntity(ctx context.Context, id any) (any, error) {
+	return r.Get(ctx, id)
+}
+
+func (r *Repository[T]) listEntities(ctx context.Context, q ListQuery) ([]any, string, error) {
+	page, err := r.List(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]any, len(page.Items))
+	for i := range page.Items {
+		items[i] = &page.Items[i]
+	}
+	return items, page.NextCursor, nil
+}
+
+func (r *Repository[T]) createEntity(ctx context.Context, entity any) error {
+	return r.Create(ctx, entity.(*T))
+}
+
+func (r *Repository[T]) updateEntity(ctx context.Context, entity any) error {
+	if r.version != nil && r.version.Value(reflect.ValueOf(entity).Elem()).Int() == 0 {
+		return NewValidationError(map[string]string{r.jsonName(r.version): "is required"})
+	}
+	return r.Update(ctx, entity.(*T))
+}
+
+func (r *Repository[T]) deleteEntity(ctx context.Context, id any) error {
+	return r.Delete(ctx, id)
+}
+
+func (r *Repository[T]) parseId(raw string) (any, error) {
+	id, err := parseFieldValue(r.pk, raw)
+	if err != nil {
+		return nil, ErrNotFound.New("%s %s does not exist", r.table.ModelName, raw)
+	}
+	return id, nil
+}
+
+func (r *Repository[T]) protect(entity any, fields []*schema.Field) func() {
+	strct := reflect.ValueOf(entity).Elem()
+	fields = append([]*schema.Field{r.pk, r.table.SoftDeleteField}, fields...)
+	saved := make([]reflect.Value, len(fields))
+	for i, f := range fields {
+		if f == nil {
+			continue
+		}
+		v := f.Value(strct)
+		saved[i] = reflect.New(v.Type()).Elem()
+		saved[i].Set(v)
+		// binding decodes into the value a pointer points to, keep a copy
+		if v.Kind() == reflect.Pointer && !v.IsNil() {
+			p := reflect.New(v.Type().Elem())
+			p.Elem().Set(v.Elem())
+			saved[i].Set(p)
+		}
+	}
+	if r.version != nil {
+		r.version.Value(strct).SetInt(0)
+	}
+	return func() {
+		for i, f := range fields {
+			if f != nil {
+				f.Value(strct).Set(saved[i])
+			}
+		}
+	}
+}
+
+func (r *Repository[T]) idOf(entity any) any {
+	return r.pk.Value(reflect.ValueOf(entity).Elem()).Interface()
+}
+
+func (r *Repository[T]) resetGenerated(entity any, fields []*schema.Field) {
+	strct := reflect.ValueOf(entity).Elem()
+	fields = append([]*schema.Field{r.version, r.table.SoftDeleteField}, fields...)
+	if r.pk.AutoIncrement || r.pk.Identity {
+		fields = append(fields, r.pk)
+	}
+	for _, f := range fields {
+		if f != nil {
+			v := f.Value(strct)
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+}
+
+func (r *Repository[T]) queryColumn(name string) (*schema.Field, bool) {
+	for _, f := range r.table.Fields {
+		if r.jsonName(f) == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// jsonName returns the name of the field in json bodies, fields hidden
+// from json have none.
+func (r *Repository[T]) jsonName(f *schema.Field) string {
+	tag := f.StructField.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return f.GoName
+}
@@ -0,0 +1,170 @@
+package draken
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+type testNote struct {
+	bun.BaseModel `bun:"table:notes"`
+
+	ID        int64     `bun:",pk,autoincrement" json:"id"`
+	OwnerID   int64     `json:"owner_id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	DeletedAt time.Time `bun:",soft_delete,nullzero" json:"deleted_at"`
+}
+
+func TestResourceUpdateKeepsProtectedFields(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	if _, err := storage.Client.NewCreateTable().Model((*testNote)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[testNote](storage, RepositoryConfig{})
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	note := &testNote{OwnerID: 1, Text: "hello", CreatedAt: created}
+	if err := repo.Create(ctx, note); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Draken{Storage: storage}
+	d.CreateRouter()
+	d.Router.Resource("/notes", repo, ResourceConfig{
+		Authorize: func(c echo.Context, op ResourceOp, entity any) error {
+			if n, ok := entity.(*testNote); ok && strconv.FormatInt(n.OwnerID, 10) != c.Request().Header.Get("X-User") {
+				return ErrForbidden.New("not the owner of the note")
+			}
+			return nil
+		},
+		ReadOnly: []string{"created_at"},
+	})
+	patch := func(body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/notes/"+strconv.FormatInt(note.ID, 10), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", "1")
+		rec := httptest.NewRecorder()
+		d.Router.Echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := patch(`{"owner_id":2}`); code != http.StatusForbidden {
+		t.Errorf("moving the note to another owner = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := patch(`{"id":99,"text":"changed","created_at":"2020-01-01T00:00:00Z","deleted_at":"2026-02-01T00:00:00Z"}`); code != http.StatusOK {
+		t.Fatalf("updating the note = %d, want %d", code, http.StatusOK)
+	}
+
+	stored, err := repo.Get(ctx, note.ID)
+	if err != nil {
+		t.Fatalf("the update deleted or moved the note: %v", err)
+	}
+	if stored.Text != "changed" || stored.OwnerID != 1 {
+		t.Errorf("stored note = %+v, want the text changed and the owner kept", stored)
+	}
+	if !stored.CreatedAt.Equal(created) {
+		t.Errorf("read only created_at was changed to %s", stored.CreatedAt)
+	}
+}
+
+type testDoc struct {
+	bun.BaseModel `bun:"table:docs"`
+
+	ID      int64  `bun:",pk,autoincrement" json:"id"`
+	Text    string `json:"text"`
+	Version int64  `json:"version"`
+}
+
+func TestResourceUpdateRequiresVersion(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	if _, err := storage.Client.NewCreateTable().Model((*testDoc)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[testDoc](storage, RepositoryConfig{})
+	doc := &testDoc{Text: "first"}
+	if err := repo.Create(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	// another writer moves the doc to version 2
+	doc.Text = "theirs"
+	if err := repo.Update(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Draken{Storage: storage}
+	d.CreateRouter()
+	d.Router.Resource("/docs", repo, ResourceConfig{})
+	patch := func(body string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/docs/"+strconv.FormatInt(doc.ID, 10), strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		d.Router.Echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"text":"mine"}`, http.StatusBadRequest},
+		{`{"text":"mine","version":1}`, http.StatusConflict},
+		{`{"text":"mine","version":2}`, http.StatusOK},
+	} {
+		if code := patch(tt.body); code != tt.want {
+			t.Errorf("patch %s = %d, want %d", tt.body, code, tt.want)
+		}
+	}
+	stored, err := repo.Get(ctx, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Text != "mine" || stored.Version != 3 {
+		t.Errorf("stored doc = %+v, want the text mine at version 3", stored)
+	}
+}
+
+func TestResourceCreateClearsReadOnlyFields(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestSqlite(t)
+	if _, err := storage.Client.NewCreateTable().Model((*testNote)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[testNote](storage, RepositoryConfig{})
+
+	d := &Draken{Storage: storage}
+	d.CreateRouter()
+	d.Router.Resource("/notes", repo, ResourceConfig{
+		Validate: func(c echo.Context, op ResourceOp, entity any) error {
+			if n := entity.(*testNote); op == ResourceCreate && n.OwnerID == 0 {
+				n.OwnerID, _ = strconv.ParseInt(c.Request().Header.Get("X-User"), 10, 64)
+			}
+			return nil
+		},
+		ReadOnly: []string{"owner_id", "created_at"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/notes", strings.NewReader(`{"id":99,"owner_id":2,"text":"hello","created_at":"2020-01-01T00:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", "1")
+	rec := httptest.NewRecorder()
+	d.Router.Echo.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the note = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	stored, err := repo.Get(ctx, int64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.OwnerID != 1 || !stored.CreatedAt.IsZero() || stored.Text != "hello" {
+		t.Errorf("stored note = %+v, want the read only fields set by the server only", stored)
+	}
+}
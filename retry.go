@@ -0,0 +1,156 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// RetryPolicy describes how often and how long a backend connection is
+// retried before giving up.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every failed attempt.
+	Multiplier float64
+	// Jitter randomizes every backoff by up to this fraction (0-1).
+	Jitter float64
+	// Deadline bounds all attempts together, zero means no deadline.
+	Deadline time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Deadline:       time.Minute,
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as not worth retrying, e.g. an unparsable DSN.
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, returns a permanent error, the attempts
+// are exhausted or ctx is done. The context handed to fn carries the
+// overall deadline of the policy.
+func (p RetryPolicy) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+
+	attempts := max(p.MaxAttempts, 1)
+	backoff := p.InitialBackoff
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return ErrConnectionFailed.Wrap(perm.err, "connecting to %s failed", name)
+		}
+		if attempt == attempts {
+			break
+		}
+
+		wait := p.jitter(backoff)
+		log.Error().Err(err).Msgf("Attempt %d/%d to connect to %s failed.", attempt, attempts, name)
+		log.Warn().Msgf("Waiting for %s before trying to establish a new connection to %s...", wait, name)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ErrConnectionFailed.Wrap(err, "connecting to %s aborted after %d attempt(s): %v", name, attempt, ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * max(p.Multiplier, 1))
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+	return ErrConnectionFailed.Wrap(err, "connecting to %s failed after %d attempt(s)", name, attempts)
+}
+
+func (p RetryPolicy) jitter(d time.Duration) time.Duration {
+	if p.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	delta := float64(d) * p.Jitter
+	return time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+}
+
+// readRetryPolicy reads a retry policy below the given key, falling back
+// to DefaultRetryPolicy for unset values.
+func readRetryPolicy(key string) RetryPolicy {
+	return readRetryPolicyOr(key, DefaultRetryPolicy())
+}
+
+// readRetryPolicyOr reads a retry policy below the given key, falling back
+// to p for unset values.
+func readRetryPolicyOr(key string, p RetryPolicy) RetryPolicy {
+	if viper.IsSet(key + ".maxAttempts") {
+		p.MaxAttempts = viper.GetInt(key + ".maxAttempts")
+	}
+	if viper.IsSet(key + ".initialBackoff") {
+		p.InitialBackoff = viper.GetDuration(key + ".initialBackoff")
+	}
+	if viper.IsSet(key + ".maxBackoff") {
+		p.MaxBackoff = viper.GetDuration(key + ".maxBackoff")
+	}
+	if viper.IsSet(key + ".multiplier") {
+		p.Multiplier = viper.GetFloat64(key + ".multiplier")
+	}
+	if viper.IsSet(key + ".jitter") {
+		p.Jitter = viper.GetFloat64(key + ".jitter")
+	}
+	if viper.IsSet(key + ".deadline") {
+		p.Deadline = viper.GetDuration(key + ".deadline")
+	}
+	return p
+}
+
+func (p RetryPolicy) validate(key string, problems *configProblems) {
+	if p.MaxAttempts < 1 {
+		problems.add(key+".maxAttempts", "must be at least 1")
+	}
+	if p.InitialBackoff < 0 {
+		problems.add(key+".initialBackoff", "must not be negative")
+	}
+	if p.MaxBackoff < 0 {
+		problems.add(key+".maxBackoff", "must not be negative")
+	}
+	if p.Multiplier < 1 {
+		problems.add(key+".multiplier", "must be at least 1")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		problems.add(key+".jitter", "must be between 0 and 1")
+	}
+	if p.Deadline < 0 {
+		problems.add(key+".deadline", "must not be negative")
+	}
+}
@@ -0,0 +1,100 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+)
+
+func TestRetryPolicyBacksOff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: 4 * time.Millisecond, MaxBackoff: 8 * time.Millisecond, Multiplier: 2}
+
+	var attempts []time.Time
+	err := p.Do(context.Background(), "test", func(ctx context.Context) error {
+		attempts = append(attempts, time.Now())
+		return errors.New("refused")
+	})
+	if !errorx.IsOfType(err, ErrConnectionFailed) {
+		t.Errorf("Do = %v, want ErrConnectionFailed", err)
+	}
+	if len(attempts) != 5 {
+		t.Fatalf("made %d attempts, want 5", len(attempts))
+	}
+	// 4ms, doubled to 8ms and capped there
+	for i, want := range []time.Duration{4, 8, 8, 8} {
+		if wait := attempts[i+1].Sub(attempts[i]); wait < want*time.Millisecond {
+			t.Errorf("waited %s before attempt %d, want at least %s", wait, i+2, want*time.Millisecond)
+		}
+	}
+
+	attempts = nil
+	err = p.Do(context.Background(), "test", func(ctx context.Context) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			return errors.New("refused")
+		}
+		return nil
+	})
+	if err != nil || len(attempts) != 3 {
+		t.Errorf("Do = %v after %d attempts, want success on the third", err, len(attempts))
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	if got := (RetryPolicy{}).jitter(time.Second); got != time.Second {
+		t.Errorf("jitter without a fraction = %s, want 1s", got)
+	}
+
+	p := RetryPolicy{Jitter: 0.2}
+	seen := map[time.Duration]bool{}
+	for range 100 {
+		got := p.jitter(time.Second)
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter = %s, want 0.8s to 1.2s", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("the jitter does not randomize the backoff")
+	}
+}
+
+func TestRetryPolicyStops(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, Multiplier: 2, Deadline: 20 * time.Millisecond}
+
+	attempts := 0
+	start := time.Now()
+	err := p.Do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("the attempt has no deadline")
+		}
+		return errors.New("refused")
+	})
+	if !errorx.IsOfType(err, ErrConnectionFailed) || attempts != 1 {
+		t.Errorf("Do = %v after %d attempts, want ErrConnectionFailed after the first", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do returned after %s, the deadline is %s", elapsed, p.Deadline)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.Deadline = 0
+	if err := p.Do(ctx, "test", func(ctx context.Context) error { return ctx.Err() }); !errorx.IsOfType(err, ErrConnectionFailed) {
+		t.Errorf("Do with a cancelled context = %v, want ErrConnectionFailed", err)
+	}
+
+	attempts = 0
+	cause := errors.New("invalid dsn")
+	err = p.Do(context.Background(), "test", func(ctx context.Context) error {
+		attempts++
+		return permanent(cause)
+	})
+	if !errorx.IsOfType(err, ErrConnectionFailed) || errorx.Cast(err).Cause() != cause || attempts != 1 {
+		t.Errorf("Do = %v after %d attempts, want the permanent error without a retry", err, attempts)
+	}
+}
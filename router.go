@@ -6,11 +6,15 @@ import (
 )
 
 type Router struct {
-	Echo         *echo.Echo
-	Group        *echo.Group
-	Draken       *Draken
+	Echo   *echo.Echo
+	Group  *echo.Group
+	Draken *Draken
+	// Prefix is the full path prefix of the router, empty for the root.
+	Prefix       string
 	ParentRouter *Router
 	Subrouters   map[string]*Router
+	// permissions are required by Require for all routes of the router.
+	permissions []string
 }
 
 func (d *Draken) CreateRouter() {
@@ -18,6 +22,7 @@ func (d *Draken) CreateRouter() {
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
+	e.HTTPErrorHandler = d.HTTPErrorHandler
 
 	g := e.Group("")
 	d.Router = &Router{
@@ -36,41 +41,43 @@ func (r *Router) CreateSubrouter(route string) *Router {
 		Echo:         r.Echo,
 		Group:        r.Group.Group(route),
 		Draken:       r.Draken,
+		Prefix:       r.Prefix + route,
 		ParentRouter: r,
 		Subrouters:   make(map[string]*Router),
 	}
 
 	r.Subrouters[route] = sr
+	sr.applyRateLimits()
 	log.Info().Str("route", route).Msgf("Created router at %s.", route)
 	return sr
 }
 
 func (r *Router) Get(route string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
 	log.Debug().Str("method", "GET").Str("route", route).Msg("Registing handler...")
-	r.Group.GET(route, handler, middlewares...)
+	r.Group.GET(route, handler, append([]echo.MiddlewareFunc{r.authorize()}, middlewares...)...)
 	log.Debug().Str("method", "GET").Str("route", route).Msg("Registered a handler")
 }
 
 func (r *Router) Post(route string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
 	log.Debug().Str("method", "POST").Str("route", route).Msg("Registing handler...")
-	r.Group.POST(route, handler, middlewares...)
+	r.Group.POST(route, handler, append([]echo.MiddlewareFunc{r.authorize()}, middlewares...)...)
 	log.Debug().Str("method", "POST").Str("route", route).Msg("Registered a handler")
 }
 
 func (r *Router) Put(route string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
 	log.Debug().Str("method", "PUT").Str("route", route).Msg("Registing handler...")
-	r.Group.PUT(route, handler, middlewares...)
+	r.Group.PUT(route, handler, append([]echo.MiddlewareFunc{r.authorize()}, middlewares...)...)
 	log.Debug().Str("method", "PUT").Str("route", route).Msg("Registered a handler")
 }
 
 func (r *Router) Patch(route string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
 	log.Debug().Str("method", "PATCH").Str("route", route).Msg("Registing handler...")
-	r.Group.PATCH(route, handler, middlewares...)
+	r.Group.PATCH(route, handler, append([]echo.MiddlewareFunc{r.authorize()}, middlewares...)...)
 	log.Debug().Str("method", "PATCH").Str("route", route).Msg("Registered a handler")
 }
 
 func (r *Router) Delete(route string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
 	log.Debug().Str("method", "DELETE").Str("route", route).Msg("Registing handler...")
-	r.Group.DELETE(route, handler, middlewares...)
+	r.Group.DELETE(route, handler, append([]echo.MiddlewareFunc{r.authorize()}, middlewares...)...)
 	log.Debug().Str("method", "DELETE").Str("route", route).Msg("Registered a handler")
 }
@@ -3,26 +3,39 @@ package draken
 import (
 	"context"
 	"database/sql"
+	"net/url"
 	"os"
-	"path"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
 
+	"github.com/joomcode/errorx"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/rs/zerolog/log"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 	"github.com/uptrace/bun"
-	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
-	"github.com/uptrace/bun/driver/pgdriver"
 	"github.com/uptrace/bun/extra/bundebug"
+	"github.com/uptrace/bun/schema"
 )
 
 type Storage interface {
 	Init(bool)
 	Stop()
 	Bun() *bun.DB
+	// Ctx is the lifetime context of the storage, handlers should pass
+	// RequestCtx instead so that queries are cancelled with the request.
 	Ctx() context.Context
+	// ReadBun returns a read replica, or the primary if there is none.
+	ReadBun() *bun.DB
+	// ReadBunCtx is ReadBun, but returns the primary if ctx forces it.
+	ReadBunCtx(ctx context.Context) *bun.DB
+	// WithTx runs fn in a transaction and retries it on conflicts.
+	WithTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx bun.Tx) error) error
+	// IDB returns the transaction of ctx or the primary.
+	IDB(ctx context.Context) bun.IDB
+	Ping(ctx context.Context) error
 }
 
 type SqlDatabase struct {
@@ -30,133 +43,177 @@ type SqlDatabase struct {
 	Client  *bun.DB
 	Context context.Context
 	Cancel  context.CancelFunc
+	// TxRetry is the retry policy of WithTx.
+	TxRetry  RetryPolicy
+	replicas *replicaSet
 }
 
-func (d *Draken) initStorage() {
+func (d *Draken) initStorage(ctx context.Context) error {
 	if !d.Config.Storage.Enabled {
 		log.Debug().Msgf("Storage is disabled in the config, skipping...")
-		return
+		return nil
 	}
 	log.Debug().Msgf("Initializing storage...")
 
+	var storage *SqlDatabase
+	var err error
 	switch d.Config.Storage.Type {
 	case StorageTypeSqlite:
-		d.Storage = NewSqlite("draken", "data")
+		storage, err = NewSqlite(ctx, d.Config.Storage.Sqlite, d.Config.Storage.Retry)
 	case StorageTypeLibsql:
-		d.Storage = NewLibsql(d.Config.Storage.DSN)
+		storage, err = NewLibsql(ctx, d.Config.Storage.DSN, d.Config.Storage.Retry)
 	case StorageTypePostgres:
-		d.Storage = NewPostgres(d.Config.Storage.DSN)
+		storage, err = NewPostgres(ctx, d.Config.Storage.DSN, d.Config.Storage.Postgres, d.Config.Storage.Retry)
+	}
+	if err != nil {
+		return err
+	}
+	storage.TxRetry = d.Config.Storage.TxRetry
+	if d.Tracing != nil {
+		storage.Client.AddQueryHook(d.Tracing.QueryHook())
+	}
+	if err := d.initReplicas(ctx, storage); err != nil {
+		storage.Stop()
+		return err
+	}
+	if err := d.initMigrations(ctx, storage); err != nil {
+		d.Migrator = nil
+		storage.Stop()
+		return err
+	}
+	d.Storage = storage
+	d.Health.AddReadinessCheck("storage", storage.Ping)
+	if d.Metrics != nil {
+		d.Metrics.Register(collectors.NewDBStatsCollector(storage.DB, "storage"))
 	}
 	log.Info().Msgf("Storage initialized.")
-
+	return nil
 }
 
-func NewSqlite(sqliteFolder ...string) *SqlDatabase {
-ConnectionStart:
+// NewSqlite opens the sqlite database at the configured path, an empty
+// path uses ~/draken/data/main.db. The pragmas are passed to the driver,
+// which applies them to every connection of the pool.
+func NewSqlite(ctx context.Context, cfg SqliteConfig, policy RetryPolicy) (*SqlDatabase, error) {
 	log.Debug().Msgf("Initializing the sqlite database...")
 
-	// Get user home directory
-	home, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to get user home directory")
+	memory := cfg.Path == ":memory:"
+	file := cfg.Path
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errorx.ExternalError.Wrap(err, "failed to get user home directory")
+		}
+		file = filepath.Join(home, "draken", "data", "main.db")
 	}
-	dataFolder := home
-
-	// Set up sqlite connection
-	for _, f := range sqliteFolder {
-		dataFolder = path.Join(dataFolder, f)
-	}
-	err = os.MkdirAll(dataFolder, 0755)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create data directory")
+	if !memory {
+		dir := filepath.Dir(file)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errorx.ExternalError.Wrap(err, "failed to create data directory %s", dir)
+		}
 	}
 
-	conn, err := sql.Open("sqlite3", filepath.Join(dataFolder, "main.db"))
+	conn, err := connect(ctx, "sqlite", policy, func() (*sql.DB, error) {
+		db, err := sql.Open("sqlite3", sqliteDSN(file, cfg))
+		if err != nil {
+			return nil, err
+		}
+		// every connection to :memory: opens a database of its own
+		if memory {
+			db.SetMaxOpenConns(1)
+			db.SetConnMaxIdleTime(0)
+			db.SetConnMaxLifetime(0)
+		}
+		return db, nil
+	})
 	if err != nil {
-		log.Error().Msgf("Failed to connect to the database: %v", err)
-		log.Warn().Msgf("Waiting for 10 seconds before trying to establish a new connection...")
-		time.Sleep(10 * time.Second)
-		goto ConnectionStart
+		return nil, err
 	}
-	bun := bun.NewDB(conn, sqlitedialect.New())
 
-	// Create context for the database
-	ctx, cancel := context.WithCancel(context.Background())
-	d := &SqlDatabase{
-		DB:      conn,
-		Client:  bun,
-		Context: ctx,
-		Cancel:  cancel,
-	}
-
-	log.Info().Msgf("Initialized sqlite database.")
-	return d
+	log.Info().Msgf("Initialized sqlite database %s.", file)
+	return newSqlDatabase(conn, sqlitedialect.New()), nil
 }
 
-// NewPostgres creates a new postgres database
-func NewPostgres(dsn string) *SqlDatabase {
-ConnectionStart:
-	log.Debug().Msgf("Initializing the postgres database...")
-
-	conn := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(dsn)))
-	err := conn.Ping()
-	if err != nil {
-		log.Error().Msgf("Failed to connect to the database: %v", err)
-		log.Warn().Msgf("Waiting for 10 seconds before trying to establish a new connection...")
-		time.Sleep(10 * time.Second)
-		goto ConnectionStart
+// sqliteDSN builds the go-sqlite3 dsn carrying the pragmas of cfg. The
+// path is escaped as sqlite decodes file uris, a ? or # would otherwise end
+// the path.
+func sqliteDSN(file string, cfg SqliteConfig) string {
+	params := url.Values{}
+	params.Set("_journal_mode", cfg.JournalMode)
+	params.Set("_synchronous", cfg.Synchronous)
+	params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(cfg.ForeignKeys))
+	if cfg.CacheSize != 0 {
+		params.Set("_cache_size", strconv.Itoa(cfg.CacheSize))
 	}
-	bun := bun.NewDB(conn, pgdialect.New())
-
-	// Create context for the database
-	ctx, cancel := context.WithCancel(context.Background())
-	d := &SqlDatabase{
-		DB:      conn,
-		Client:  bun,
-		Context: ctx,
-		Cancel:  cancel,
+	segments := strings.Split(filepath.ToSlash(file), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
-
-	log.Info().Msgf("Initialized postgres database.")
-	return d
+	return "file:" + strings.Join(segments, "/") + "?" + params.Encode()
 }
 
 // NewLibsql creates a new libsql database
-func NewLibsql(dsn string) *SqlDatabase {
-ConnectionStart:
+func NewLibsql(ctx context.Context, dsn string, policy RetryPolicy) (*SqlDatabase, error) {
 	log.Debug().Msgf("Initializing the libsql database...")
 
-	conn, err := sql.Open("libsql", dsn)
+	conn, err := connect(ctx, "libsql", policy, func() (*sql.DB, error) {
+		return sql.Open("libsql", dsn)
+	})
 	if err != nil {
-		log.Error().Msgf("Failed to connect to the database: %v", err)
-		log.Warn().Msgf("Waiting for 10 seconds before trying to establish a new connection...")
-		time.Sleep(10 * time.Second)
-		goto ConnectionStart
+		return nil, err
 	}
-	bun := bun.NewDB(conn, sqlitedialect.New())
 
+	log.Info().Msgf("Initialized libsql database.")
+	return newSqlDatabase(conn, sqlitedialect.New()), nil
+}
+
+// connect opens and pings a database following the retry policy.
+func connect(ctx context.Context, name string, policy RetryPolicy, open func() (*sql.DB, error)) (*sql.DB, error) {
+	var conn *sql.DB
+	err := policy.Do(ctx, name, func(ctx context.Context) error {
+		db, err := open()
+		if err != nil {
+			return err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return err
+		}
+		conn = db
+		return nil
+	})
+	return conn, err
+}
+
+func newSqlDatabase(conn *sql.DB, dialect schema.Dialect) *SqlDatabase {
 	// Create context for the database
 	ctx, cancel := context.WithCancel(context.Background())
-	d := &SqlDatabase{
+	return &SqlDatabase{
 		DB:      conn,
-		Client:  bun,
+		Client:  bun.NewDB(conn, dialect),
 		Context: ctx,
 		Cancel:  cancel,
+		TxRetry: DefaultTxRetryPolicy(),
 	}
-
-	log.Info().Msgf("Initialized libsql database.")
-	return d
 }
 
 func (d *SqlDatabase) Init(debug bool) {
-	d.Client.AddQueryHook(bundebug.NewQueryHook(
+	hook := bundebug.NewQueryHook(
 		bundebug.WithVerbose(debug),
 		bundebug.WithEnabled(debug),
 		bundebug.WithWriter(log.Logger),
-	))
+	)
+	d.Client.AddQueryHook(hook)
+	for _, r := range d.Replicas() {
+		r.Client.AddQueryHook(hook)
+	}
 }
 
 func (d *SqlDatabase) Stop() {
+	d.Cancel()
+	for _, r := range d.Replicas() {
+		r.Client.Close()
+	}
 	d.Client.Close()
 }
 
@@ -167,3 +224,7 @@ func (d *SqlDatabase) Bun() *bun.DB {
 func (d *SqlDatabase) Ctx() context.Context {
 	return d.Context
 }
+
+func (d *SqlDatabase) Ping(ctx context.Context) error {
+	return d.DB.PingContext(ctx)
+}
@@ -0,0 +1,162 @@
+package draken
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"net/url"
+	"os"
+
+	"github.com/joomcode/errorx"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// NewPostgres creates a new postgres database. The settings of cfg take
+// precedence over the parameters of the dsn.
+func NewPostgres(ctx context.Context, dsn string, cfg PostgresConfig, policy RetryPolicy) (*SqlDatabase, error) {
+	log.Debug().Msgf("Initializing the postgres database...")
+
+	opts, err := postgresOptions(dsn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connect(ctx, "postgres", policy, func() (*sql.DB, error) {
+		db, err := openPostgres(opts, cfg)
+		if err != nil {
+			return nil, permanent(err)
+		}
+		return db, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Int("max_open_conns", cfg.MaxOpenConns).
+		Int("max_idle_conns", cfg.MaxIdleConns).
+		Dur("conn_max_lifetime", cfg.ConnMaxLifetime).
+		Dur("conn_max_idle_time", cfg.ConnMaxIdleTime).
+		Dur("dial_timeout", cfg.DialTimeout).
+		Dur("read_timeout", cfg.ReadTimeout).
+		Dur("write_timeout", cfg.WriteTimeout).
+		Dur("statement_timeout", cfg.StatementTimeout).
+		Str("application_name", cfg.ApplicationName).
+		Str("tls", cfg.Tls.Mode.String()).
+		Msgf("Initialized postgres database.")
+	return newSqlDatabase(conn, pgdialect.New()), nil
+}
+
+// postgresOptions returns the driver options of the dsn and cfg.
+func postgresOptions(dsn string, cfg PostgresConfig) ([]pgdriver.Option, error) {
+	opts := []pgdriver.Option{
+		pgdriver.WithDSN(dsn),
+		pgdriver.WithApplicationName(cfg.ApplicationName),
+		pgdriver.WithDialTimeout(cfg.DialTimeout),
+		pgdriver.WithReadTimeout(cfg.ReadTimeout),
+		pgdriver.WithWriteTimeout(cfg.WriteTimeout),
+	}
+	if cfg.StatementTimeout > 0 {
+		// merged into the params of the dsn, WithConnParams would replace them
+		opts = append(opts, func(conf *pgdriver.Config) {
+			if conf.ConnParams == nil {
+				conf.ConnParams = make(map[string]any)
+			}
+			conf.ConnParams["statement_timeout"] = cfg.StatementTimeout.Milliseconds()
+		})
+	}
+	if cfg.Tls.Mode != PostgresTlsDsn {
+		tlsConfig, err := postgresTlsConfig(dsn, cfg.Tls)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			opts = append(opts, pgdriver.WithInsecure(true))
+		} else {
+			opts = append(opts, pgdriver.WithTLSConfig(tlsConfig))
+		}
+	}
+	return opts, nil
+}
+
+// openPostgres opens the connection pool without connecting.
+func openPostgres(opts []pgdriver.Option, cfg PostgresConfig) (db *sql.DB, err error) {
+	// pgdriver panics on malformed DSNs
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrInvalidConfig.New("invalid postgres dsn: %v", r)
+		}
+	}()
+	db = sql.OpenDB(pgdriver.NewConnector(opts...))
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	return db, nil
+}
+
+// postgresTlsConfig builds the tls config of the mode, nil disables tls.
+func postgresTlsConfig(dsn string, cfg PostgresTlsConfig) (*tls.Config, error) {
+	if cfg.Mode == PostgresTlsDisable {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+	if tlsConfig.ServerName == "" {
+		if u, err := url.Parse(dsn); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+	}
+	if cfg.CaFile != "" {
+		raw, err := os.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, errorx.InitializationFailed.Wrap(err, "reading the postgres ca file failed")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(raw) {
+			return nil, errorx.InitializationFailed.New("the postgres ca file %s holds no certificates", cfg.CaFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errorx.InitializationFailed.Wrap(err, "loading the postgres client certificate failed")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch cfg.Mode {
+	case PostgresTlsRequire:
+		tlsConfig.InsecureSkipVerify = true
+	case PostgresTlsVerifyCa:
+		// tls.Config cannot verify the chain without the host name, so the
+		// chain is verified by hand
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, tlsConfig.RootCAs)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errorx.IllegalState.New("the server sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	return err
+}
@@ -0,0 +1,246 @@
+package draken
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+)
+
+// latencyWeight is the weight of a new sample in the moving average of the
+// replica latency.
+const latencyWeight = 0.3
+
+// Replica is a read replica of the primary database.
+type Replica struct {
+	Name   string
+	DB     *sql.DB
+	Client *bun.DB
+
+	healthy atomic.Bool
+	// latency is the moving average of the ping latency in nanoseconds
+	latency atomic.Int64
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+func (r *Replica) Latency() time.Duration {
+	return time.Duration(r.latency.Load())
+}
+
+// replicaSet selects the replica reads are sent to.
+type replicaSet struct {
+	replicas  []*Replica
+	selection ReplicaSelection
+	next      atomic.Uint64
+}
+
+// pick returns a healthy replica, nil if there is none.
+func (s *replicaSet) pick() *Replica {
+	switch s.selection {
+	case ReplicaSelectionLeastLatency:
+		var best *Replica
+		for _, r := range s.replicas {
+			if r.Healthy() && (best == nil || r.Latency() < best.Latency()) {
+				best = r
+			}
+		}
+		return best
+	default:
+		// rotating over the healthy replicas only spreads the reads of an
+		// ejected replica evenly
+		healthy := make([]*Replica, 0, len(s.replicas))
+		for _, r := range s.replicas {
+			if r.Healthy() {
+				healthy = append(healthy, r)
+			}
+		}
+		if len(healthy) == 0 {
+			return nil
+		}
+		return healthy[s.next.Add(1)%uint64(len(healthy))]
+	}
+}
+
+// check pings every replica, ejecting the failing ones and readmitting the
+// ones that recovered.
+func (s *replicaSet) check(ctx context.Context, timeout time.Duration) {
+	for _, r := range s.replicas {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := r.DB.PingContext(pingCtx)
+		cancel()
+
+		if err != nil {
+			if r.healthy.Swap(false) {
+				log.Warn().Err(err).Str("replica", r.Name).Msg("Replica failed its health check, sending its reads to other replicas.")
+			}
+			continue
+		}
+		sample := time.Since(start)
+		if old := r.latency.Load(); old == 0 {
+			r.latency.Store(int64(sample))
+		} else {
+			r.latency.Store(int64(latencyWeight*float64(sample) + (1-latencyWeight)*float64(old)))
+		}
+		if !r.healthy.Swap(true) {
+			log.Info().Str("replica", r.Name).Msg("Replica is healthy, sending reads to it.")
+		}
+	}
+}
+
+func (s *replicaSet) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.check(ctx, interval)
+		}
+	}
+}
+
+func (d *Draken) initReplicas(ctx context.Context, storage *SqlDatabase) error {
+	cfg := d.Config.Storage.Replicas
+	if len(cfg.Dsns) == 0 {
+		return nil
+	}
+	log.Debug().Msgf("Initializing %d read replicas...", len(cfg.Dsns))
+
+	for i, dsn := range cfg.Dsns {
+		db, client, err := d.openReplica(dsn)
+		if err != nil {
+			return err
+		}
+
+		name := "replica-" + strconv.Itoa(i)
+		if u, err := url.Parse(dsn); err == nil && u.Host != "" {
+			name = u.Host
+		}
+		if d.Tracing != nil {
+			client.AddQueryHook(d.Tracing.QueryHook())
+		}
+		if d.Metrics != nil {
+			d.Metrics.Register(collectors.NewDBStatsCollector(db, "storage_replica_"+strconv.Itoa(i)))
+		}
+		storage.AddReplica(name, db, client)
+	}
+
+	storage.StartReplicaChecks(cfg.Selection, cfg.CheckInterval)
+	log.Info().Msgf("Initialized %d read replicas.", len(cfg.Dsns))
+	return nil
+}
+
+// openReplica opens the pool of a replica without connecting to it, an
+// unreachable replica must not fail the startup. It stays ejected until a
+// health check succeeds.
+func (d *Draken) openReplica(dsn string) (*sql.DB, *bun.DB, error) {
+	switch d.Config.Storage.Type {
+	case StorageTypeLibsql:
+		db, err := sql.Open("libsql", dsn)
+		if err != nil {
+			return nil, nil, ErrInvalidConfig.Wrap(err, "invalid libsql replica dsn")
+		}
+		return db, bun.NewDB(db, sqlitedialect.New()), nil
+	case StorageTypePostgres:
+		opts, err := postgresOptions(dsn, d.Config.Storage.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		db, err := openPostgres(opts, d.Config.Storage.Postgres)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, bun.NewDB(db, pgdialect.New()), nil
+	default:
+		return nil, nil, ErrInvalidConfig.New("read replicas are not supported by the storage type")
+	}
+}
+
+// AddReplica adds a read replica. It receives reads once a health check of
+// StartReplicaChecks succeeded.
+func (d *SqlDatabase) AddReplica(name string, db *sql.DB, client *bun.DB) {
+	if d.replicas == nil {
+		d.replicas = &replicaSet{}
+	}
+	d.replicas.replicas = append(d.replicas.replicas, &Replica{Name: name, DB: db, Client: client})
+}
+
+// StartReplicaChecks sets the selection of the replicas, admits the
+// healthy ones and checks their health every interval until the storage is
+// stopped.
+func (d *SqlDatabase) StartReplicaChecks(selection ReplicaSelection, interval time.Duration) {
+	if d.replicas == nil {
+		return
+	}
+	d.replicas.selection = selection
+	d.replicas.check(d.Context, interval)
+	for _, r := range d.replicas.replicas {
+		if !r.Healthy() {
+			log.Warn().Str("replica", r.Name).Msg("Replica is unreachable, its reads go to other replicas until it recovers.")
+		}
+	}
+	go d.replicas.run(d.Context, interval)
+}
+
+// Replicas returns the read replicas of the database.
+func (d *SqlDatabase) Replicas() []*Replica {
+	if d.replicas == nil {
+		return nil
+	}
+	return d.replicas.replicas
+}
+
+// ReadBun returns the client of a healthy replica, or the primary if there
+// are no healthy replicas. Replicas may lag behind the primary, use
+// ReadBunCtx with WithPrimary to read your own writes.
+func (d *SqlDatabase) ReadBun() *bun.DB {
+	if d.replicas == nil {
+		return d.Client
+	}
+	if r := d.replicas.pick(); r != nil {
+		return r.Client
+	}
+	return d.Client
+}
+
+// ReadBunCtx returns the read client for ctx, the primary if ctx was marked
+// by WithPrimary or ForcePrimary.
+func (d *SqlDatabase) ReadBunCtx(ctx context.Context) *bun.DB {
+	if PrimaryForced(ctx) {
+		return d.Client
+	}
+	return d.ReadBun()
+}
+
+type primaryCtxKey struct{}
+
+// WithPrimary marks ctx so that reads made with it go to the primary.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+// PrimaryForced reports whether ctx was marked by WithPrimary.
+func PrimaryForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return forced
+}
+
+// ForcePrimary sends the remaining reads of the request to the primary,
+// e.g. after a write the request reads back.
+func ForcePrimary(c echo.Context) {
+	c.SetRequest(c.Request().WithContext(WithPrimary(c.Request().Context())))
+}
@@ -0,0 +1,71 @@
+package draken
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSqlite opens an in-memory sqlite database closed with the test.
+func newTestSqlite(t *testing.T) *SqlDatabase {
+	t.Helper()
+	storage, err := NewSqlite(context.Background(), SqliteConfig{
+		Path:        ":memory:",
+		JournalMode: "MEMORY",
+		Synchronous: "NORMAL",
+		ForeignKeys: true,
+	}, DefaultRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Stop)
+	return storage
+}
+
+func TestUnreachableReplicaIsEjected(t *testing.T) {
+	storage := newTestSqlite(t)
+	healthy := newTestSqlite(t)
+	down := newTestSqlite(t)
+	down.DB.Close()
+
+	storage.AddReplica("down", down.DB, down.Client)
+	storage.AddReplica("healthy", healthy.DB, healthy.Client)
+	storage.StartReplicaChecks(ReplicaSelectionRoundRobin, time.Hour)
+
+	for range 4 {
+		if db := storage.ReadBun(); db != healthy.Client {
+			t.Fatal("reads were not sent to the healthy replica")
+		}
+	}
+	if db := storage.ReadBunCtx(WithPrimary(context.Background())); db != storage.Client {
+		t.Error("forced reads were not sent to the primary")
+	}
+}
+
+func TestSqliteDSNEscapesPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data #1?%")
+	storage, err := NewSqlite(context.Background(), SqliteConfig{
+		Path:        filepath.Join(dir, "main 100%.db"),
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: time.Second,
+		ForeignKeys: true,
+	}, DefaultRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(storage.Stop)
+
+	var mode string
+	if err := storage.Client.NewRaw("PRAGMA journal_mode").Scan(context.Background(), &mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal mode = %s, the pragmas were dropped", mode)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main 100%.db")); err != nil {
+		t.Errorf("database was not created at the configured path: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package draken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/driver/pgdriver"
+)
+
+// DefaultTxRetryPolicy retries conflicting transactions quickly, the
+// conflicts are short lived. Its Deadline bounds the retries, a transaction
+// may run longer.
+func DefaultTxRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		Deadline:       10 * time.Second,
+	}
+}
+
+type txCtxKey struct{}
+
+// TxFrom returns the transaction stored on ctx by WithTx or TxMiddleware.
+func TxFrom(ctx context.Context) (bun.Tx, bool) {
+	tx, ok := ctx.Value(txCtxKey{}).(bun.Tx)
+	return tx, ok
+}
+
+// IDB returns the transaction of ctx, or the primary if there is none, so
+// that queries join the transaction of the request.
+func (d *SqlDatabase) IDB(ctx context.Context) bun.IDB {
+	if tx, ok := TxFrom(ctx); ok {
+		return tx
+	}
+	return d.Client
+}
+
+// WithTx runs fn in a transaction, committing if fn returns nil and rolling
+// back otherwise. Serialization failures and deadlocks on postgres and busy
+// or locked databases on sqlite and libsql are retried with backoff, so fn
+// must not have side effects outside of the transaction. No retry starts
+// after the Deadline of TxRetry, fn itself is only bounded by ctx. If ctx
+// already carries a transaction fn joins it and is not retried on its own.
+func (d *SqlDatabase) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx bun.Tx) error) error {
+	if tx, ok := TxFrom(ctx); ok {
+		return fn(ctx, tx)
+	}
+
+	p := d.TxRetry
+	start := time.Now()
+	attempts := max(p.MaxAttempts, 1)
+	backoff := p.InitialBackoff
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = d.Client.RunInTx(ctx, opts, func(ctx context.Context, tx bun.Tx) error {
+			return fn(context.WithValue(ctx, txCtxKey{}, tx), tx)
+		})
+		if err == nil || !RetryableTxError(err) || attempt == attempts {
+			break
+		}
+
+		wait := p.jitter(backoff)
+		if p.Deadline > 0 && time.Since(start)+wait > p.Deadline {
+			return ErrConflict.Wrap(err, "transaction failed after %d attempt(s), retrying would exceed %s", attempt, p.Deadline)
+		}
+		log.Debug().Err(err).Msgf("Transaction attempt %d/%d conflicted, retrying in %s...", attempt, attempts, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ErrConflict.Wrap(err, "transaction aborted after %d attempt(s): %v", attempt, ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * max(p.Multiplier, 1))
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+	if err != nil && RetryableTxError(err) {
+		return ErrConflict.Wrap(err, "transaction failed after %d attempt(s)", attempts)
+	}
+	return err
+}
+
+// RetryableTxError reports whether err is a transient conflict that is
+// resolved by running the transaction again.
+func RetryableTxError(err error) bool {
+	var pgErr pgdriver.Error
+	if errors.As(err, &pgErr) {
+		switch pgErr.Field('C') {
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		}
+		return false
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	// libsql only reports the error message
+	msg := err.Error()
+	return strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "SQLITE_LOCKED")
+}
+
+// TxConfig configures TxMiddleware.
+type TxConfig struct {
+	Options *sql.TxOptions
+	// Skipper skips the transaction, e.g. for reads. Defaults to skipping
+	// GET, HEAD and OPTIONS requests.
+	Skipper func(c echo.Context) bool
+}
+
+// TxMiddleware runs every request in a transaction of the storage. It is
+// committed if the handler returns nil and rolled back otherwise, use
+// TxFrom or IDB with the request context to join it. The request is not
+// retried on conflicts and a failed commit cannot change a response the
+// handler already sent, handlers that must report commit failures should
+// use WithTx instead.
+func TxMiddleware(storage Storage) echo.MiddlewareFunc {
+	return TxMiddlewareWithConfig(storage, TxConfig{})
+}
+
+func TxMiddlewareWithConfig(storage Storage, cfg TxConfig) echo.MiddlewareFunc {
+	if cfg.Skipper == nil {
+		cfg.Skipper = func(c echo.Context) bool {
+			switch c.Request().Method {
+			case echo.GET, echo.HEAD, echo.OPTIONS:
+				return true
+			}
+			return false
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if cfg.Skipper(c) {
+				return next(c)
+			}
+
+			ctx := RequestCtx(c)
+			tx, err := storage.Bun().BeginTx(ctx, cfg.Options)
+			if err != nil {
+				return ErrUnavailable.Wrap(err, "starting the transaction failed")
+			}
+			c.SetRequest(c.Request().WithContext(context.WithValue(ctx, txCtxKey{}, tx)))
+
+			committed := false
+			defer func() {
+				if !committed {
+					// runs on errors and panics, the request context may be done
+					if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+						log.Error().Err(err).Msg("Rolling back the request transaction failed.")
+					}
+				}
+			}()
+
+			if err := next(c); err != nil {
+				return err
+			}
+			committed = true
+			if err := tx.Commit(); err != nil {
+				if RetryableTxError(err) {
+					return ErrConflict.Wrap(err, "committing the transaction failed")
+				}
+				return ErrInternal.Wrap(err, "committing the transaction failed")
+			}
+			return nil
+		}
+	}
+}
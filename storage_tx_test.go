@@ -0,0 +1,158 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"github.com/uptrace/bun"
+)
+
+// newTestTxStorage returns a sqlite storage with the items table and a
+// quick transaction retry policy.
+func newTestTxStorage(t *testing.T) *SqlDatabase {
+	t.Helper()
+	storage := newTestSqlite(t)
+	if _, err := storage.Client.NewCreateTable().Model((*testItem)(nil)).Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	storage.TxRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2, Deadline: time.Second}
+	return storage
+}
+
+func countItems(t *testing.T, storage *SqlDatabase) int {
+	t.Helper()
+	n, err := storage.Client.NewSelect().Model((*testItem)(nil)).Count(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRetryableTxError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{errors.New("database is locked (SQLITE_BUSY)"), true},
+		{errors.New("no such table: items"), false},
+	} {
+		if got := RetryableTxError(tt.err); got != tt.want {
+			t.Errorf("RetryableTxError(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWithTxRetriesBusyErrors(t *testing.T) {
+	storage := newTestTxStorage(t)
+
+	attempts := 0
+	err := storage.WithTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		attempts++
+		if _, err := tx.NewInsert().Model(&testItem{Name: "a"}).Exec(ctx); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("ran %d attempts, want 3", attempts)
+	}
+	if n := countItems(t, storage); n != 1 {
+		t.Errorf("%d rows were committed, the failed attempts were not rolled back", n)
+	}
+}
+
+func TestWithTxGivesUpAfterMaxAttempts(t *testing.T) {
+	storage := newTestTxStorage(t)
+
+	attempts := 0
+	err := storage.WithTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		attempts++
+		return sqlite3.Error{Code: sqlite3.ErrLocked}
+	})
+	if !errorx.IsOfType(err, ErrConflict) {
+		t.Errorf("WithTx = %v, want ErrConflict", err)
+	}
+	if attempts != 3 {
+		t.Errorf("ran %d attempts, want 3", attempts)
+	}
+
+	attempts = 0
+	err = storage.WithTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		attempts++
+		return ErrValidation.New("invalid")
+	})
+	if !errorx.IsOfType(err, ErrValidation) || attempts != 1 {
+		t.Errorf("WithTx = %v after %d attempts, want the error of the only attempt", err, attempts)
+	}
+}
+
+func TestWithTxDeadlineOnlyBoundsRetries(t *testing.T) {
+	storage := newTestTxStorage(t)
+	storage.TxRetry.Deadline = 10 * time.Millisecond
+
+	err := storage.WithTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		time.Sleep(30 * time.Millisecond)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := tx.NewInsert().Model(&testItem{Name: "slow"}).Exec(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("a transaction outliving the retry deadline failed: %v", err)
+	}
+
+	attempts := 0
+	err = storage.WithTx(context.Background(), nil, func(ctx context.Context, tx bun.Tx) error {
+		attempts++
+		time.Sleep(10 * time.Millisecond)
+		return sqlite3.Error{Code: sqlite3.ErrBusy}
+	})
+	if !errorx.IsOfType(err, ErrConflict) || attempts != 1 {
+		t.Errorf("WithTx = %v after %d attempts, want ErrConflict without a retry past the deadline", err, attempts)
+	}
+}
+
+func TestTxMiddleware(t *testing.T) {
+	storage := newTestTxStorage(t)
+	e := echo.New()
+	e.POST("/items", func(c echo.Context) error {
+		ctx := RequestCtx(c)
+		if _, err := storage.IDB(ctx).NewInsert().Model(&testItem{Name: c.QueryParam("name")}).Exec(ctx); err != nil {
+			return err
+		}
+		if c.QueryParam("fail") != "" {
+			return ErrValidation.New("rejected")
+		}
+		return c.NoContent(http.StatusCreated)
+	}, TxMiddleware(storage))
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"name=kept", 1},
+		{"name=dropped&fail=1", 1},
+	} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/items?"+tt.query, nil))
+		if n := countItems(t, storage); n != tt.want {
+			t.Errorf("after %s there are %d rows, want %d", tt.query, n, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+)
+
+const ContextKeyTimeout ContextKey = "draken-timeout"
+
+// timeoutState is stored on the echo context by TimeoutMiddleware so that
+// route timeouts can replace the default deadline.
+type timeoutState struct {
+	// parent is the request context before the default deadline, it is
+	// cancelled when the client goes away
+	parent  context.Context
+	metrics *Metrics
+	// elapsed is set by the route timeout that handled an elapsed deadline,
+	// so that the default timeout does not count it again
+	elapsed bool
+}
+
+// TimeoutConfig configures a route timeout.
+type TimeoutConfig struct {
+	// Timeout is the deadline of the request, zero disables it.
+	Timeout time.Duration
+	// Status is returned when the deadline elapsed before a response was
+	// written, either 504 (the default) or 503.
+	Status int
+}
+
+// TimeoutMiddleware applies the default request timeout and counts the
+// requests whose deadline elapsed. It is added by EssentialMiddlewares.
+func TimeoutMiddleware(timeout time.Duration, m *Metrics) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(string(ContextKeyTimeout), &timeoutState{parent: c.Request().Context(), metrics: m})
+			return withTimeout(c, next, TimeoutConfig{Timeout: timeout})
+		}
+	}
+}
+
+// Timeout overrides the default request timeout of a route, e.g.
+// r.Post("/export", handler, draken.Timeout(50*time.Second)). The deadline
+// may be longer than the default one, but the server closes connections
+// after draken.server.writeTimeout, so longer deadlines have no effect
+// unless the write timeout is raised as well.
+func Timeout(timeout time.Duration) echo.MiddlewareFunc {
+	return TimeoutWithConfig(TimeoutConfig{Timeout: timeout})
+}
+
+// TimeoutWithConfig is Timeout with a configurable status.
+func TimeoutWithConfig(cfg TimeoutConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// drop the default deadline but keep the values later middlewares
+			// stored on the context, e.g. the principal or the transaction
+			if state, ok := c.Get(string(ContextKeyTimeout)).(*timeoutState); ok {
+				ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request().Context()))
+				defer cancel()
+				stop := context.AfterFunc(state.parent, cancel)
+				defer stop()
+				c.SetRequest(c.Request().WithContext(ctx))
+			}
+			return withTimeout(c, next, cfg)
+		}
+	}
+}
+
+// withTimeout runs next with the deadline of cfg and turns an elapsed
+// deadline into ErrTimeout or ErrUnavailable.
+func withTimeout(c echo.Context, next echo.HandlerFunc, cfg TimeoutConfig) error {
+	if cfg.Timeout <= 0 {
+		return next(c)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), cfg.Timeout)
+	defer cancel()
+	c.SetRequest(c.Request().WithContext(ctx))
+
+	err := next(c)
+	state, _ := c.Get(string(ContextKeyTimeout)).(*timeoutState)
+	// an inner route timeout already handled the deadline
+	if (state != nil && state.elapsed) || errorx.IsOfType(err, ErrTimeout) || errorx.IsOfType(err, ErrUnavailable) {
+		return err
+	}
+	if !errors.Is(RequestCtx(c).Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	if state != nil {
+		state.elapsed = true
+		if state.metrics != nil {
+			state.metrics.ObserveTimeout(c.Path(), c.Request().Method)
+		}
+	}
+	if c.Response().Committed {
+		return err
+	}
+	if err == nil {
+		err = context.DeadlineExceeded
+	}
+	if cfg.Status == http.StatusServiceUnavailable {
+		return ErrUnavailable.Wrap(err, "request timed out after %s", cfg.Timeout)
+	}
+	return ErrTimeout.Wrap(err, "request timed out after %s", cfg.Timeout)
+}
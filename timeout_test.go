@@ -0,0 +1,62 @@
+package draken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRouteTimeoutIsCountedOnce(t *testing.T) {
+	m := NewMetrics()
+	e := echo.New()
+	e.Use(TimeoutMiddleware(time.Hour, m))
+	e.GET("/slow", func(c echo.Context) error {
+		// the response is committed before the deadline elapses
+		if err := c.String(http.StatusOK, "partial"); err != nil {
+			return err
+		}
+		<-RequestCtx(c).Done()
+		return nil
+	}, Timeout(10*time.Millisecond))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if n := testutil.ToFloat64(m.timeouts.WithLabelValues("/slow", http.MethodGet)); n != 1 {
+		t.Errorf("counted %v timeouts, want 1", n)
+	}
+}
+
+func TestRouteTimeoutKeepsContextValues(t *testing.T) {
+	e := echo.New()
+	e.Use(TimeoutMiddleware(10*time.Millisecond, nil))
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			SetPrincipal(c, &Principal{Id: "1"})
+			return next(c)
+		}
+	})
+	e.GET("/export", func(c echo.Context) error {
+		// outlives the default deadline
+		time.Sleep(20 * time.Millisecond)
+		if err := RequestCtx(c).Err(); err != nil {
+			return err
+		}
+		if p := GetRequest(c.Request()).Principal(); p == nil || p.Id != "1" {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return c.NoContent(http.StatusOK)
+	}, Timeout(time.Second))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
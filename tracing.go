@@ -0,0 +1,287 @@
+package draken
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/yzaimoglu/draken"
+
+type TracingExporter uint8
+
+const (
+	TracingExporterStdout TracingExporter = iota
+	TracingExporterOtlp
+	TracingExporterUnknown TracingExporter = 255
+)
+
+type TracingConfig struct {
+	Enabled     bool
+	Exporter    TracingExporter
+	ServiceName string
+	// Endpoint is the host:port of the otlp http collector.
+	Endpoint string
+	Insecure bool
+	// SampleRatio is the fraction of new traces that are sampled.
+	SampleRatio float64
+}
+
+// Tracing owns the tracer provider all draken spans are created with.
+type Tracing struct {
+	Provider *sdktrace.TracerProvider
+	Tracer   trace.Tracer
+}
+
+// NewTracing creates the tracer provider and registers it globally. A
+// non-nil exporter replaces the configured one and is exported synchronously,
+// which allows tests to use an in-memory exporter.
+func NewTracing(ctx context.Context, cfg TracingConfig, exporter sdktrace.SpanExporter) (*Tracing, error) {
+	var processor sdktrace.SpanProcessor
+	if exporter != nil {
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	} else {
+		var err error
+		switch cfg.Exporter {
+		case TracingExporterOtlp:
+			opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+			if cfg.Insecure {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			exporter, err = otlptracehttp.New(ctx, opts...)
+		default:
+			exporter, err = stdouttrace.New()
+		}
+		if err != nil {
+			return nil, errorx.InitializationFailed.Wrap(err, "creating the trace exporter failed")
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, errorx.InitializationFailed.Wrap(err, "creating the trace resource failed")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	log.Info().Msgf("Tracing initialized.")
+	return &Tracing{
+		Provider: provider,
+		Tracer:   provider.Tracer(tracerName),
+	}, nil
+}
+
+func (d *Draken) initTracing(ctx context.Context) error {
+	if !d.Config.Tracing.Enabled {
+		log.Debug().Msgf("Tracing is disabled in the config, skipping...")
+		return nil
+	}
+	log.Debug().Msgf("Initializing tracing...")
+
+	tracing, err := NewTracing(ctx, d.Config.Tracing, d.options.SpanExporter)
+	if err != nil {
+		return err
+	}
+	d.Tracing = tracing
+	return nil
+}
+
+// Stop flushes all pending spans.
+func (t *Tracing) Stop(ctx context.Context) error {
+	return t.Provider.Shutdown(ctx)
+}
+
+// TracingMiddleware creates a server span per request, continuing traces
+// propagated by the caller. The span carries the draken request id so
+// traces and request logs can be correlated.
+func TracingMiddleware(t *Tracing) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			req := c.Request()
+			route := c.Path()
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+			ctx, span := t.Tracer.Start(ctx, fmt.Sprintf("%s %s", req.Method, route),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", req.Method),
+					attribute.String("http.route", route),
+					attribute.String("url.path", req.URL.Path),
+					attribute.String("client.address", CloudflareCompatibleIP(c)),
+				),
+			)
+			defer span.End()
+			if id, ok := c.Get(string(ContextKeyRequestId)).(string); ok {
+				span.SetAttributes(attribute.String("draken.request_id", id))
+			}
+
+			c.SetRequest(req.WithContext(ctx))
+			if err = next(c); err != nil {
+				span.RecordError(err)
+			}
+
+			status := responseStatus(c, err)
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return err
+		}
+	}
+}
+
+// TraceId returns the id of the trace the request belongs to, or an empty
+// string if the request is not traced.
+func TraceId(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// QueryHook creates a client span per bun query.
+func (t *Tracing) QueryHook() bun.QueryHook {
+	return &tracingQueryHook{tracer: t.Tracer}
+}
+
+type tracingQueryHook struct {
+	tracer trace.Tracer
+}
+
+func (h *tracingQueryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	statement := event.QueryTemplate
+	if statement == "" {
+		statement = event.Query
+	}
+
+	ctx, _ = h.tracer.Start(ctx, "db."+event.Operation(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", event.DB.Dialect().Name().String()),
+			attribute.String("db.operation.name", event.Operation()),
+			attribute.String("db.query.text", statement),
+		),
+	)
+	return ctx
+}
+
+func (h *tracingQueryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	if event.Err != nil && !errors.Is(event.Err, sql.ErrNoRows) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+}
+
+// RedisHook creates a client span per redis command and pipeline.
+func (t *Tracing) RedisHook() redis.Hook {
+	return &tracingRedisHook{tracer: t.Tracer}
+}
+
+type tracingRedisHook struct {
+	tracer trace.Tracer
+}
+
+func (h *tracingRedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *tracingRedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := h.tracer.Start(ctx, "redis."+cmd.FullName(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation.name", cmd.FullName()),
+			),
+		)
+		defer span.End()
+
+		err := next(ctx, cmd)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func (h *tracingRedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := h.tracer.Start(ctx, "redis.pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.Int("db.operation.batch.size", len(cmds)),
+			),
+		)
+		defer span.End()
+
+		err := next(ctx, cmds)
+		if err != nil && !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+// S3Middleware adds a client span per s3 operation to the client's
+// middleware stack.
+func (t *Tracing) S3Middleware() func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("DrakenTracing",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					operation := awsmiddleware.GetOperationName(ctx)
+					ctx, span := t.Tracer.Start(ctx, "S3."+operation,
+						trace.WithSpanKind(trace.SpanKindClient),
+						trace.WithAttributes(
+							attribute.String("rpc.system", "aws-api"),
+							attribute.String("rpc.service", awsmiddleware.GetServiceID(ctx)),
+							attribute.String("rpc.method", operation),
+						),
+					)
+					defer span.End()
+
+					out, md, err := next.HandleInitialize(ctx, in)
+					if err != nil {
+						span.RecordError(err)
+						span.SetStatus(codes.Error, err.Error())
+					}
+					return out, md, err
+				}), middleware.Before)
+		})
+	}
+}
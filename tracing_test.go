@@ -0,0 +1,134 @@
+package draken
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingSpans(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	tracing, err := NewTracing(ctx, TracingConfig{ServiceName: "test", SampleRatio: 1}, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracing.Stop(ctx) })
+
+	storage := newTestSqlite(t)
+	storage.Client.AddQueryHook(tracing.QueryHook())
+
+	mr := miniredis.RunT(t)
+	cache, err := NewRedis(ctx, "redis://"+mr.Addr(), DefaultRetryPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cache.Stop)
+	cache.Client.AddHook(tracing.RedisHook())
+
+	var logs bytes.Buffer
+	e := echo.New()
+	e.Use(RequestIdMiddleware(), TracingMiddleware(tracing), LoggerMiddleware(zerolog.New(&logs)))
+	e.GET("/orders/:id", func(c echo.Context) error {
+		ctx := RequestCtx(c)
+		var n int
+		if err := storage.Client.NewSelect().ColumnExpr("1").Scan(ctx, &n); err != nil {
+			return err
+		}
+		if err := cache.SetCtx(ctx, "order", "1", 0); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server, ok := spans["GET /orders/:id"]
+	if !ok {
+		t.Fatalf("no server span in %v", exporter.GetSpans().Snapshots())
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span kind = %v", server.SpanKind)
+	}
+	for _, name := range []string{"db.SELECT", "redis.set"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.SpanKind != trace.SpanKindClient {
+			t.Errorf("%s span kind = %v", name, span.SpanKind)
+		}
+		if span.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the server span", name)
+		}
+	}
+
+	var line struct {
+		TraceId string `json:"trace_id"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if want := server.SpanContext.TraceID().String(); line.TraceId != want {
+		t.Errorf("logged trace_id = %q, want %q", line.TraceId, want)
+	}
+}
+
+func TestTracingErrorStatus(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	tracing, err := NewTracing(ctx, TracingConfig{ServiceName: "test", SampleRatio: 1}, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracing.Stop(ctx) })
+
+	d := &Draken{}
+	d.CreateRouter()
+	handled := 0
+	handler := d.Router.Echo.HTTPErrorHandler
+	d.Router.Echo.HTTPErrorHandler = func(err error, c echo.Context) {
+		handled++
+		handler(err, c)
+	}
+	d.Router.Middleware(TracingMiddleware(tracing))
+	d.Router.Get("/fail", func(c echo.Context) error { return ErrInternal.New("broken") })
+
+	rec := httptest.NewRecorder()
+	d.Router.Echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	if rec.Code != http.StatusInternalServerError || handled != 1 {
+		t.Errorf("status = %d after handling the error %d times, want %d once", rec.Code, handled, http.StatusInternalServerError)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans, want 1", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error {
+		t.Errorf("span status = %v, want an error", spans[0].Status)
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "http.response.status_code" && attr.Value.AsInt64() != http.StatusInternalServerError {
+			t.Errorf("span status code = %d, want %d", attr.Value.AsInt64(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package draken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/xid"
+	"github.com/rs/zerolog/log"
+)
+
+// signedUploadGrace is how long after the signed upload expired the client
+// may still complete it.
+const signedUploadGrace = 15 * time.Minute
+
+// SignedUploadConfig configures the direct to bucket upload routes
+// registered by Router.SignedUpload.
+type SignedUploadConfig struct {
+	// KeyPrefix returns the prefix the uploads of the requesting user are
+	// stored under, e.g. "users/<id>/". It must end with a slash so that no
+	// prefix contains another. Returning an error rejects the request.
+	KeyPrefix func(c echo.Context) (string, error)
+	// MaxSize is the largest accepted upload in bytes, 0 means no limit.
+	MaxSize int64
+	// ContentTypes are the accepted content types, empty accepts any.
+	ContentTypes []string
+	// Expiry is how long the signed upload is valid, defaults to 15 minutes.
+	Expiry time.Duration
+	// Post signs a form upload instead of a PUT url. R2 only supports PUT.
+	Post bool
+	// OnComplete is called once the client reported a finished upload and
+	// the object was found in the bucket.
+	OnComplete func(c echo.Context, info *ObjectInfo) error
+}
+
+// SignedUploadRequest is the body of the signing route.
+type SignedUploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// SignedUploadResponse tells the client where to upload the file to.
+// Either Request or Post is set.
+type SignedUploadResponse struct {
+	Key     string            `json:"key"`
+	Request *PresignedRequest `json:"request,omitempty"`
+	Post    *PresignedPost    `json:"post,omitempty"`
+}
+
+// SignedUploadCompletion is the body of the completion route.
+type SignedUploadCompletion struct {
+	Key string `json:"key"`
+}
+
+// SignedUpload registers POST route, which signs an upload below the
+// user's key prefix, and POST route/complete, which the client calls after
+// the upload finished. The object store has to implement Presigner. The
+// issued keys are remembered in the cache, completion accepts a key once
+// and only if it was issued to the same prefix. It returns
+// ErrInvalidConfig if the object store or the cache is missing.
+func (r *Router) SignedUpload(route string, cfg SignedUploadConfig, middlewares ...echo.MiddlewareFunc) error {
+	if cfg.KeyPrefix == nil {
+		return ErrInvalidConfig.New("signed upload route %s needs a KeyPrefix function", route)
+	}
+	if r.Draken.Cache == nil {
+		return ErrInvalidConfig.New("signed upload route %s needs a cache", route)
+	}
+	presigner, ok := r.Draken.ObjectStore.(Presigner)
+	if !ok {
+		return ErrInvalidConfig.New("signed upload route %s needs an object store supporting presigned requests", route)
+	}
+	if cfg.Expiry <= 0 {
+		cfg.Expiry = defaultPresignExpiry
+	}
+	r.Post(route, r.signUpload(cfg, presigner), middlewares...)
+	r.Post(strings.TrimSuffix(route, "/")+"/complete", r.completeUpload(cfg), middlewares...)
+	return nil
+}
+
+func (r *Router) signUpload(cfg SignedUploadConfig, presigner Presigner) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req SignedUploadRequest
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		fields := map[string]string{}
+		if !cfg.Post && req.Size <= 0 {
+			fields["size"] = "must be greater than 0"
+		}
+		if cfg.MaxSize > 0 && req.Size > cfg.MaxSize {
+			fields["size"] = "exceeds the maximum upload size"
+		}
+		if len(cfg.ContentTypes) > 0 && !slices.Contains(cfg.ContentTypes, req.ContentType) {
+			fields["content_type"] = "is not accepted"
+		}
+		if len(fields) > 0 {
+			return NewValidationError(fields)
+		}
+
+		prefix, err := uploadPrefix(c, cfg)
+		if err != nil {
+			return err
+		}
+		key := prefix + xid.New().String() + strings.ToLower(path.Ext(path.Base(req.Filename)))
+
+		opts := []ObjectOption{WithExpiry(cfg.Expiry)}
+		if req.ContentType != "" {
+			opts = append(opts, WithContentType(req.ContentType))
+		}
+		resp := SignedUploadResponse{Key: key}
+		ctx := c.Request().Context()
+		if cfg.Post {
+			if cfg.MaxSize > 0 {
+				opts = append(opts, WithSizeLimit(1, cfg.MaxSize))
+			}
+			resp.Post, err = presigner.PresignPost(ctx, key, opts...)
+		} else {
+			opts = append(opts, WithContentLength(req.Size))
+			resp.Request, err = presigner.PresignPut(ctx, key, opts...)
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.Draken.Cache.SetCtx(ctx, signedUploadCacheKey(key), prefix, cfg.Expiry+signedUploadGrace); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+func (r *Router) completeUpload(cfg SignedUploadConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req SignedUploadCompletion
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		prefix, err := uploadPrefix(c, cfg)
+		if err != nil {
+			return err
+		}
+		ctx := c.Request().Context()
+		// the key is client supplied, only keys signed for this prefix are
+		// accepted so that objects of others are never touched, and only
+		// once so that a replay does not complete the upload again
+		if ok, err := r.consumeUpload(ctx, req.Key, prefix); err != nil {
+			return err
+		} else if !ok {
+			return NewValidationError(map[string]string{"key": "is not an upload of this user"})
+		}
+
+		store := r.Draken.ObjectStore
+		info, err := store.Head(ctx, req.Key)
+		if err != nil {
+			return err
+		}
+
+		// the signature binds most constraints, check them again anyway as
+		// form uploads only bind the size range
+		if (cfg.MaxSize > 0 && info.Size > cfg.MaxSize) ||
+			(len(cfg.ContentTypes) > 0 && !slices.Contains(cfg.ContentTypes, info.ContentType)) {
+			if err := store.Delete(ctx, req.Key); err != nil {
+				log.Error().Err(err).Str("key", req.Key).Msg("Deleting rejected upload failed.")
+			}
+			return NewValidationError(map[string]string{"key": "the uploaded object violates the upload constraints"})
+		}
+
+		if cfg.OnComplete != nil {
+			if err := cfg.OnComplete(c, info); err != nil {
+				return err
+			}
+		}
+		return c.JSON(http.StatusOK, info)
+	}
+}
+
+// uploadPrefix returns the key prefix of the user, rejecting prefixes that
+// would contain the prefixes of other users.
+func uploadPrefix(c echo.Context, cfg SignedUploadConfig) (string, error) {
+	prefix, err := cfg.KeyPrefix(c)
+	if err != nil {
+		return "", err
+	}
+	if prefix == "" || !strings.HasSuffix(prefix, "/") {
+		return "", ErrInternal.New("upload key prefix %q must not be empty and end with a slash", prefix)
+	}
+	return prefix, nil
+}
+
+// consumeUpload reports whether key was signed by the upload route for
+// prefix, has not expired and was not completed yet. It removes the key.
+func (r *Router) consumeUpload(ctx context.Context, key, prefix string) (bool, error) {
+	if key == "" || !strings.HasPrefix(key, prefix) {
+		return false, nil
+	}
+	issuedTo, err := r.Draken.Cache.GetDelCtx(ctx, signedUploadCacheKey(key))
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return *issuedTo == prefix, nil
+}
+
+func signedUploadCacheKey(key string) string {
+	return "draken:upload:" + key
+}
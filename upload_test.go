@@ -0,0 +1,118 @@
+package draken
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSignedUploadOnlyCompletesIssuedKeys(t *testing.T) {
+	ctx := context.Background()
+	r2 := newTestR2(t)
+	d := &Draken{Cache: NewLocal(), ObjectStore: r2}
+	d.CreateRouter()
+	completed := 0
+	err := d.Router.SignedUpload("/uploads", SignedUploadConfig{
+		KeyPrefix: func(c echo.Context) (string, error) {
+			return "users/" + c.Request().Header.Get("X-User") + "/", nil
+		},
+		ContentTypes: []string{"text/plain"},
+		OnComplete: func(c echo.Context, info *ObjectInfo) error {
+			completed++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(user, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		d.Router.Echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// an object of user 10 the upload routes did not issue to user 1
+	if _, err := r2.Put(ctx, "users/10/avatar.png", strings.NewReader("png"), WithContentType("image/png")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := post("1", "/uploads", `{"filename":"a.txt","content_type":"text/plain","size":5}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signing failed with %d: %s", rec.Code, rec.Body)
+	}
+	var signed SignedUploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &signed); err != nil {
+		t.Fatal(err)
+	}
+	upload, _ := http.NewRequest(signed.Request.Method, signed.Request.URL, strings.NewReader("hello"))
+	upload.Header = signed.Request.Header
+	upload.ContentLength = 5
+	resp, err := http.DefaultClient.Do(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, tt := range []struct {
+		user, key string
+		want      int
+	}{
+		{"1", "users/10/avatar.png", http.StatusBadRequest},
+		{"10", signed.Key, http.StatusBadRequest},
+		{"1", signed.Key, http.StatusOK},
+		// a replayed completion
+		{"1", signed.Key, http.StatusBadRequest},
+	} {
+		if rec := post(tt.user, "/uploads/complete", `{"key":"`+tt.key+`"}`); rec.Code != tt.want {
+			t.Errorf("user %s completing %s = %d, want %d: %s", tt.user, tt.key, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if _, err := r2.Head(ctx, "users/10/avatar.png"); errorx.IsOfType(err, ErrNotFound) {
+		t.Error("the object of another user was deleted")
+	}
+	if completed != 1 {
+		t.Errorf("OnComplete ran %d times, want once", completed)
+	}
+}
+
+func TestSignedUploadRejectsOpenPrefixes(t *testing.T) {
+	d := &Draken{Cache: NewLocal(), ObjectStore: newTestR2(t)}
+	d.CreateRouter()
+	err := d.Router.SignedUpload("/uploads", SignedUploadConfig{
+		KeyPrefix: func(c echo.Context) (string, error) { return "users/1", nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/uploads/complete", strings.NewReader(`{"key":"users/10/a.txt"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	d.Router.Echo.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("prefix without a trailing slash = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestSignedUploadNeedsCacheAndObjectStore(t *testing.T) {
+	cfg := SignedUploadConfig{KeyPrefix: func(c echo.Context) (string, error) { return "users/1/", nil }}
+	for name, d := range map[string]*Draken{
+		"no cache":        {ObjectStore: newTestR2(t)},
+		"no object store": {Cache: NewLocal()},
+		"no presigner":    {Cache: NewLocal(), ObjectStore: NewMemoryObjectStore("test-bucket")},
+	} {
+		d.CreateRouter()
+		if err := d.Router.SignedUpload("/uploads", cfg); !errorx.IsOfType(err, ErrInvalidConfig) {
+			t.Errorf("%s: SignedUpload = %v, want ErrInvalidConfig", name, err)
+		}
+	}
+}